@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"mediacontrol/pkg/auth"
+	"mediacontrol/pkg/commands"
+	"mediacontrol/pkg/device"
+	"mediacontrol/pkg/input"
+	"mediacontrol/pkg/policy"
+	"mediacontrol/pkg/signing"
+	"mediacontrol/pkg/transport"
 	"mediacontrol/pkg/websocket"
-	vk "mediacontrol/pkg/winVirtualKeyCodes"
 	"os"
 	"path/filepath"
-	"syscall"
-	"unsafe"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -21,9 +32,8 @@ import (
 )
 
 var (
-	user32        = syscall.NewLazyDLL("user32.dll")
-	sendInputProc = user32.NewProc("SendInput")
-	consoleLog    = flag.Bool("console", false, "Enable console logging")
+	consoleLog = flag.Bool("console", false, "Enable console logging")
+	executor   *commands.Executor
 )
 
 func init() {
@@ -47,36 +57,6 @@ func init() {
 	}
 }
 
-func keyPressOnce(keyCode uint16) {
-	type keyboardInput struct {
-		wVk         uint16
-		wScan       uint16
-		dwFlags     uint32
-		time        uint32
-		dwExtraInfo uint64
-	}
-
-	type input struct {
-		inputType uint32
-		ki        keyboardInput
-		padding   uint64
-	}
-
-	var i input
-	i.inputType = 1 //INPUT_KEYBOARD
-	i.ki.wVk = keyCode
-	ret, _, _ := sendInputProc.Call(
-		uintptr(1),
-		uintptr(unsafe.Pointer(&i)),
-		uintptr(unsafe.Sizeof(i)),
-	)
-	if ret != 1 {
-		log.Printf("Failed to send keypress for keycode %d: unexpected return value %d", keyCode, ret)
-	} else {
-		log.Printf("Successfully sent keypress for keycode %d", keyCode)
-	}
-}
-
 type AppConfig struct {
 	App struct {
 		Name    string `yaml:"name"`
@@ -85,6 +65,15 @@ type AppConfig struct {
 			WebappURL string `yaml:"webapp_url"`
 			TokenFile string `yaml:"token_file"`
 		} `yaml:"auth"`
+		Input struct {
+			Backend string `yaml:"backend"`
+		} `yaml:"input"`
+		Commands   commands.Config                 `yaml:"commands"`
+		Macros     map[string][]commands.MacroStep `yaml:"macros"`
+		Policy     policy.Config                   `yaml:"policy"`
+		Signing    signing.Config                  `yaml:"signing"`
+		Device     device.Config                   `yaml:"device"`
+		Connection websocket.Config                `yaml:"connection"`
 	} `yaml:"app"`
 }
 
@@ -108,12 +97,53 @@ func loadConfig() (*AppConfig, error) {
 	return &config, nil
 }
 
-func handleKeyPress(keyCode string) {
-	if vkCode, ok := vk.VirtualKeyCodes[keyCode]; ok {
-		keyPressOnce(vkCode)
-	} else {
-		log.Printf("Unknown key code: %s", keyCode)
+func handleKeyPress(msg websocket.KeyCodeMessage) error {
+	backend := executor.Injector().Name()
+	if err := executor.ExecuteKeys(msg); err != nil {
+		log.Printf("Failed to send keypress for %+v via %s: %v", msg.Strokes(), backend, err)
+		return err
+	}
+	log.Printf("Successfully sent keypress for %+v via %s", msg.Strokes(), backend)
+	return nil
+}
+
+func handleTypeText(msg websocket.TypeTextMessage) error {
+	backend := executor.Injector().Name()
+	if err := executor.TypeText(msg); err != nil {
+		log.Printf("Failed to type %d characters via %s: %v", len([]rune(msg.Text)), backend, err)
+		return err
+	}
+	log.Printf("Successfully typed %d characters via %s", len([]rune(msg.Text)), backend)
+	return nil
+}
+
+func handleMacro(msg websocket.MacroMessage) error {
+	if err := executor.RunMacro(msg.Name); err != nil {
+		log.Printf("Failed to run macro %s: %v", msg.Name, err)
+		return err
 	}
+	log.Printf("Successfully ran macro %s", msg.Name)
+	return nil
+}
+
+// setupSigning loads the desktop's signing key, creating it when pairing for
+// the first time, and the verifier for the enrolled phone keys.
+func setupSigning(cfg signing.Config) (string, *signing.Verifier, error) {
+	key, err := signing.LoadOrCreateKey(cfg.KeyFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("error loading signing key: %v", err)
+	}
+	verifier, err := signing.NewVerifier(cfg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	publicKey := key.Public().(ed25519.PublicKey)
+	log.Printf("Signed commands enabled, desktop key fingerprint %s", signing.Fingerprint(publicKey))
+	if len(cfg.PhoneKeys) == 0 {
+		log.Printf("Warning: no phone keys enrolled, every command will be rejected")
+	}
+	return signing.EncodePublicKey(publicKey), verifier, nil
 }
 
 type StatusLabel struct {
@@ -130,18 +160,50 @@ func NewStatusLabel() *StatusLabel {
 	return label
 }
 
-func (l *StatusLabel) SetConnected(connected bool) {
-	l.connected = connected
-	if connected {
+func (l *StatusLabel) SetStatus(status websocket.Status) {
+	l.connected = status.Connected
+	switch {
+	case status.Connected && time.Now().Before(status.LockedUntil):
+		wait := time.Until(status.LockedUntil).Round(time.Second)
+		l.SetText(fmt.Sprintf("Online (too many commands, paused for %v)", wait))
+		l.Importance = widget.WarningImportance
+	case status.Connected:
 		l.SetText("Online")
 		l.Importance = widget.SuccessImportance
-	} else {
+	case !status.NextRetry.IsZero():
+		wait := time.Until(status.NextRetry).Round(time.Second)
+		l.SetText(fmt.Sprintf("Reconnecting (attempt %d in %v)", status.Attempt, max(wait, 0)))
+		l.Importance = widget.WarningImportance
+	case errors.Is(status.Err, websocket.ErrIncompatibleProtocol):
+		l.SetText("Update required")
+		l.Importance = widget.DangerImportance
+	case errors.Is(status.Err, websocket.ErrUnauthorized):
+		l.SetText("Login required")
+		l.Importance = widget.DangerImportance
+	default:
 		l.SetText("Offline")
 		l.Importance = widget.DangerImportance
 	}
 	l.Refresh()
 }
 
+// formatMetrics summarizes the connection quality for the window and tray.
+func formatMetrics(m websocket.Metrics) string {
+	var parts []string
+	if m.Connected {
+		if m.AvgLatency > 0 {
+			parts = append(parts, fmt.Sprintf("%d ms", m.AvgLatency.Milliseconds()))
+		}
+		parts = append(parts, "up "+m.Uptime.Round(time.Second).String())
+	} else if m.LastDisconnect != "" {
+		parts = append(parts, "last drop: "+m.LastDisconnect)
+	}
+	if m.Reconnects > 0 {
+		parts = append(parts, fmt.Sprintf("%d reconnects", m.Reconnects))
+	}
+	return strings.Join(parts, ", ")
+}
+
 func main() {
 	config, err := loadConfig()
 	if err != nil {
@@ -149,6 +211,49 @@ func main() {
 		return
 	}
 
+	identity, err := device.LoadOrCreate(config.App.Device.Path())
+	if err != nil {
+		log.Printf("Error loading device identity: %v", err)
+		return
+	}
+	log.Printf("Device %s (%s)", identity.Name, identity.ID)
+
+	// Without a working input backend the app still starts, so that the
+	// user sees why commands fail.
+	var inputWarning string
+	injector, err := input.New(config.App.Input.Backend)
+	if err != nil && config.App.Input.Backend == "" && input.FallbackBackend != "" {
+		log.Printf("Error creating input backend %s, falling back to %s: %v", input.DefaultBackend, input.FallbackBackend, err)
+		inputWarning = fmt.Sprintf("Only media keys work (%v)", err)
+		injector, err = input.New(input.FallbackBackend)
+	}
+	if err != nil {
+		log.Printf("Error creating input backend: %v", err)
+		inputWarning = fmt.Sprintf("Commands cannot run: %v", err)
+		injector = input.Unavailable(err)
+	}
+	defer injector.Close()
+	log.Printf("Using input backend: %s", injector.Name())
+	executor = commands.NewExecutor(injector, config.App.Commands)
+	defer executor.ReleaseAll()
+	if err := executor.SetMacros(config.App.Macros); err != nil {
+		log.Printf("Error loading macros: %v", err)
+		return
+	}
+
+	commandPolicy, err := policy.New(config.App.Policy)
+	if err != nil {
+		log.Printf("Error loading policy: %v", err)
+		return
+	}
+
+	httpClient, err := transport.HTTPClient(config.App.Connection.TLS, config.App.Connection.Proxy, 0)
+	if err != nil {
+		log.Printf("Error configuring network access: %v", err)
+		return
+	}
+	auth.SetHTTPClient(httpClient)
+
 	a := app.New()
 	w := a.NewWindow(config.App.Name)
 
@@ -167,12 +272,18 @@ func main() {
 
 	label := widget.NewLabel("Audara Pre-MVP baby")
 	playButton := widget.NewButton("Play", func() {
-		keyPressOnce(vk.VirtualKeyCodes["VK_MEDIA_PLAY_PAUSE"])
+		handleKeyPress(websocket.KeyCodeMessage{KeyCode: "media.play_pause"})
 	})
 	playButton.Disable()
 
 	statusLabel := NewStatusLabel()
 
+	inputLabel := widget.NewLabel(inputWarning)
+	inputLabel.Wrapping = fyne.TextWrapWord
+	if inputWarning == "" {
+		inputLabel.Hide()
+	}
+
 	reconnectButton := widget.NewButton("Reconnect", nil)
 	reconnectButton.Hide()
 
@@ -183,7 +294,46 @@ func main() {
 
 	var loginHandler func()
 	var cancelAuth func()
-	var wsClient *websocket.Client
+	var (
+		wsClient   *websocket.Client
+		stopClient context.CancelFunc
+		clientMu   sync.Mutex
+	)
+	disconnectClient := func() {
+		clientMu.Lock()
+		defer clientMu.Unlock()
+
+		if stopClient != nil {
+			stopClient()
+		}
+		wsClient, stopClient = nil, nil
+	}
+	defer disconnectClient()
+
+	deviceEntry := widget.NewEntry()
+	deviceEntry.SetText(identity.Name)
+	deviceEntry.OnSubmitted = func(text string) {
+		name, err := device.CleanName(text)
+		if err != nil {
+			log.Printf("Invalid device name: %v", err)
+			deviceEntry.SetText(identity.Name)
+			return
+		}
+
+		clientMu.Lock()
+		identity.Name = name
+		client := wsClient
+		clientMu.Unlock()
+
+		if err := identity.Save(config.App.Device.Path()); err != nil {
+			log.Printf("Error saving device name: %v", err)
+		}
+		if client != nil {
+			if err := client.RenameDevice(name); err != nil {
+				log.Printf("Error sending device name: %v", err)
+			}
+		}
+	}
 
 	updateUI := func(userData *auth.UserData) {
 		fyne.Do(func() {
@@ -202,13 +352,12 @@ func main() {
 				authButton.SetText("Logout")
 				playButton.Enable()
 				authButton.OnTapped = func() {
-					if wsClient != nil {
-						wsClient.Close()
-						wsClient = nil
-					}
+					disconnectClient()
 					if err := os.Remove(config.App.Auth.TokenFile); err != nil {
 						log.Printf("Error removing token file: %v", err)
 					}
+					statusLabel.SetStatus(websocket.Status{})
+					reconnectButton.Hide()
 					userInfo.SetText("")
 					authButton.SetText("Login")
 					playButton.Disable()
@@ -223,6 +372,103 @@ func main() {
 		})
 	}
 
+	connectClient := func(token *auth.TokenResponse) {
+		disconnectClient()
+
+		client := websocket.NewClient(config.App.Auth.WebappURL, token.SessionToken, token.UserID)
+		client.SetKeyPressHandler(handleKeyPress)
+		client.SetTypeTextHandler(handleTypeText)
+		client.SetMacroHandler(handleMacro)
+		client.SetPolicy(commandPolicy)
+		hello := websocket.HelloMessage{
+			AppVersion:      config.App.Version,
+			OS:              runtime.GOOS,
+			InputBackend:    executor.Injector().Name(),
+			AllowedCommands: commandPolicy.AllowedCommands(),
+			AllowedKeys:     commandPolicy.AllowedKeys(),
+		}
+		if config.App.Signing.Enabled {
+			publicKey, verifier, err := setupSigning(config.App.Signing)
+			if err != nil {
+				log.Printf("Error setting up signed commands, not connecting: %v", err)
+				return
+			}
+			client.SetVerifier(verifier)
+			hello.PublicKey = publicKey
+		}
+		client.SetHello(hello)
+		client.SetConfig(config.App.Connection)
+		clientMu.Lock()
+		client.SetDevice(identity.ID, identity.Name)
+		clientMu.Unlock()
+		currentToken := token
+		client.SetTokenRefresher(func() (string, error) {
+			refreshed, err := auth.RefreshToken(currentToken, config.App.Auth.WebappURL)
+			if errors.Is(err, auth.ErrSessionExpired) {
+				return "", fmt.Errorf("%w: %v", websocket.ErrUnauthorized, err)
+			}
+			if err != nil {
+				return "", err
+			}
+			if err := auth.SaveToken(refreshed, config.App.Auth.TokenFile); err != nil {
+				log.Printf("Error saving token: %v", err)
+			}
+			currentToken = refreshed
+			return refreshed.SessionToken, nil
+		})
+		client.SetConnectionStatusHandler(func(status websocket.Status) {
+			if !status.Connected {
+				executor.ReleaseAll()
+			}
+			// A new login, or a logout, has replaced this client; its last
+			// statuses must not overwrite the current one.
+			clientMu.Lock()
+			current := wsClient == client
+			clientMu.Unlock()
+			if !current {
+				return
+			}
+
+			// The token is dead and could not be refreshed: log out rather
+			// than retry.
+			expired := errors.Is(status.Err, websocket.ErrUnauthorized)
+			if expired {
+				disconnectClient()
+				if err := os.Remove(config.App.Auth.TokenFile); err != nil {
+					log.Printf("Error removing token file: %v", err)
+				}
+				updateUI(nil)
+			}
+			fyne.Do(func() {
+				statusLabel.SetStatus(status)
+				switch {
+				case expired:
+					reconnectButton.Hide()
+					userInfo.SetText("Session expired, please log in again")
+				case status.Connected:
+					reconnectButton.Hide()
+				case errors.Is(status.Err, websocket.ErrIncompatibleProtocol):
+					// The client has stopped; reconnecting cannot help
+					// until the app is updated.
+					reconnectButton.Hide()
+				default:
+					reconnectButton.Show()
+				}
+			})
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		clientMu.Lock()
+		wsClient, stopClient = client, cancel
+		clientMu.Unlock()
+
+		go func() {
+			if err := client.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("WebSocket client stopped: %v", err)
+			}
+		}()
+	}
+
 	loginHandler = func() {
 		resultChan, cancel := auth.StartAuthProcess(config.App.Auth.WebappURL, 3001)
 		cancelAuth = cancel
@@ -270,21 +516,7 @@ func main() {
 				userData.Profile = result.Token.Profile
 			}
 
-			wsClient = websocket.NewClient(config.App.Auth.WebappURL, result.Token.SessionToken, result.Token.UserID)
-			wsClient.SetKeyPressHandler(handleKeyPress)
-			wsClient.SetConnectionStatusHandler(func(connected bool) {
-				fyne.Do(func() {
-					statusLabel.SetConnected(connected)
-					if connected {
-						reconnectButton.Hide()
-					} else {
-						reconnectButton.Show()
-					}
-				})
-			})
-			if err := wsClient.Connect(); err != nil {
-				log.Printf("Error connecting to WebSocket: %v", err)
-			}
+			connectClient(result.Token)
 
 			updateUI(userData)
 			log.Printf("Successfully authenticated")
@@ -307,35 +539,32 @@ func main() {
 			playButton.Enable()
 		})
 
-		wsClient = websocket.NewClient(config.App.Auth.WebappURL, token.SessionToken, token.UserID)
-		wsClient.SetKeyPressHandler(handleKeyPress)
-		wsClient.SetConnectionStatusHandler(func(connected bool) {
-			fyne.Do(func() {
-				statusLabel.SetConnected(connected)
-				if connected {
-					reconnectButton.Hide()
-				} else {
-					reconnectButton.Show()
-				}
-			})
-		})
-		if err := wsClient.Connect(); err != nil {
-			log.Printf("Error connecting to WebSocket: %v", err)
-		}
+		connectClient(token)
 	}
 
 	reconnectButton.OnTapped = func() {
-		if wsClient != nil {
-			if err := wsClient.Connect(); err != nil {
+		clientMu.Lock()
+		client := wsClient
+		clientMu.Unlock()
+
+		if client != nil {
+			if err := client.Connect(); err != nil {
 				log.Printf("Error reconnecting to WebSocket: %v", err)
 			}
 		}
 	}
 
+	metricsLabel := widget.NewLabel("")
+	trayMetrics := fyne.NewMenuItem("Offline", nil)
+	trayMetrics.Disabled = true
+	var trayMenu *fyne.Menu
+
 	content := container.NewVBox(
 		label,
-		container.NewHBox(statusLabel, reconnectButton),
+		container.NewHBox(statusLabel, reconnectButton, metricsLabel),
+		inputLabel,
 		container.NewHBox(userInfo, loadingLabel, authButton),
+		container.NewBorder(nil, nil, widget.NewLabel("Device name"), nil, deviceEntry),
 		playButton,
 	)
 
@@ -343,7 +572,9 @@ func main() {
 	w.Resize(fyne.NewSize(300, 150))
 
 	if desk, ok := a.(desktop.App); ok {
-		menu := fyne.NewMenu("Audara",
+		trayMenu = fyne.NewMenu("Audara",
+			trayMetrics,
+			fyne.NewMenuItemSeparator(),
 			fyne.NewMenuItem("Open App", func() {
 				w.Show()
 			}),
@@ -351,12 +582,38 @@ func main() {
 				a.Quit()
 			}),
 		)
-		desk.SetSystemTrayMenu(menu)
+		desk.SetSystemTrayMenu(trayMenu)
 		if icon != nil {
 			desk.SetSystemTrayIcon(icon)
 		}
 	}
 
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			clientMu.Lock()
+			client := wsClient
+			clientMu.Unlock()
+
+			text := ""
+			if client != nil {
+				text = formatMetrics(client.Metrics())
+			}
+			fyne.Do(func() {
+				metricsLabel.SetText(text)
+				trayMetrics.Label = statusLabel.Text
+				if text != "" {
+					trayMetrics.Label += " - " + text
+				}
+				if trayMenu != nil {
+					trayMenu.Refresh()
+				}
+			})
+		}
+	}()
+
 	w.Hide()
 	w.ShowAndRun()
 }
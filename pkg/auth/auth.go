@@ -2,6 +2,7 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -38,17 +39,42 @@ type UserData struct {
 	Profile  Profile `json:"profile"`
 }
 
+// ErrSessionExpired means the server no longer accepts the session and the
+// user has to log in again.
+var ErrSessionExpired = errors.New("session expired")
+
 type AuthResult struct {
 	Token *TokenResponse
 	Error error
 }
 
 var (
+	httpClient         = &http.Client{}
+	httpClientMu       sync.Mutex
 	callbackRegistered bool
 	callbackMutex      sync.Mutex
 	rnd                = rand.New(rand.NewSource(time.Now().UnixNano()))
 )
 
+// SetHTTPClient sets the client used for requests to the web app, e.g. one
+// that goes through a proxy. Per-request timeouts are applied on top of it.
+func SetHTTPClient(client *http.Client) {
+	httpClientMu.Lock()
+	defer httpClientMu.Unlock()
+
+	httpClient = client
+}
+
+// newHTTPClient returns a copy of the configured client with timeout set.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	httpClientMu.Lock()
+	defer httpClientMu.Unlock()
+
+	client := *httpClient
+	client.Timeout = timeout
+	return &client
+}
+
 func generateShortCode() string {
 	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
 	b := make([]byte, 6)
@@ -77,9 +103,7 @@ func WaitForAuthCallback(port int, webappURL string, defcode string) (*TokenResp
 	errorChan := make(chan error)
 
 	go func() {
-		client := &http.Client{
-			Timeout: 60 * time.Second,
-		}
+		client := newHTTPClient(60 * time.Second)
 
 		req, err := http.NewRequest("GET", webappURL+"/api/gettoken?defcode="+defcode, nil)
 		if err != nil {
@@ -150,7 +174,7 @@ func IsAuthenticated(tokenFile string) bool {
 }
 
 func VerifyToken(token *TokenResponse, webappURL string) (*UserData, error) {
-	client := &http.Client{}
+	client := newHTTPClient(0)
 	req, err := http.NewRequest("GET", webappURL+"/api/checktoken", nil)
 	if err != nil {
 		log.Printf("Error creating HTTP request: %v", err)
@@ -189,6 +213,50 @@ func VerifyToken(token *TokenResponse, webappURL string) (*UserData, error) {
 	return &userData, nil
 }
 
+// RefreshToken asks the web app for a new session token in exchange for the
+// current one, so that an expired websocket session can resume without
+// another browser login. It returns ErrSessionExpired when the server
+// refuses, or has no refresh endpoint, meaning the user has to log in again.
+func RefreshToken(token *TokenResponse, webappURL string) (*TokenResponse, error) {
+	client := newHTTPClient(30 * time.Second)
+	req, err := http.NewRequest("POST", webappURL+"/api/refreshtoken", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.SessionToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return nil, fmt.Errorf("%w (refresh returned %d)", ErrSessionExpired, resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var refreshed TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&refreshed); err != nil {
+		return nil, fmt.Errorf("error decoding token response: %v", err)
+	}
+	if refreshed.SessionToken == "" {
+		return nil, fmt.Errorf("refresh response has no session token")
+	}
+
+	// The refresh response may leave out what did not change.
+	if refreshed.UserID == "" {
+		refreshed.UserID = token.UserID
+	}
+	if refreshed.Profile.FirstName == "" && len(refreshed.Profile.EmailAddresses) == 0 {
+		refreshed.Profile = token.Profile
+	}
+	return &refreshed, nil
+}
+
 func resetCallbackRegistration() {
 	callbackMutex.Lock()
 	callbackRegistered = false
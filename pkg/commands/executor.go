@@ -0,0 +1,335 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"mediacontrol/pkg/input"
+	"mediacontrol/pkg/internal/defaults"
+	"mediacontrol/pkg/keys"
+	"mediacontrol/pkg/websocket"
+)
+
+// Config holds the caps applied to commands coming from the server. Zero
+// values fall back to the defaults below.
+type Config struct {
+	MaxHoldMs           int `yaml:"max_hold_ms"`
+	MaxRepeat           int `yaml:"max_repeat"`
+	MaxSequence         int `yaml:"max_sequence"`
+	MinRepeatIntervalMs int `yaml:"min_repeat_interval_ms"`
+	MaxRepeatIntervalMs int `yaml:"max_repeat_interval_ms"`
+	HoldTimeoutMs       int `yaml:"hold_timeout_ms"`
+	MaxTextLength       int `yaml:"max_text_length"`
+	TypeCharsPerSecond  int `yaml:"type_chars_per_second"`
+	// Commands run one at a time, so a long macro delays the commands
+	// behind it. MaxMacroDurationMs caps the delays plus the typing time of
+	// a whole macro.
+	MaxMacroDelayMs    int `yaml:"max_macro_delay_ms"`
+	MaxMacroDurationMs int `yaml:"max_macro_duration_ms"`
+}
+
+const (
+	defaultMaxHold           = 5 * time.Second
+	defaultMaxRepeat         = 20
+	defaultMaxSequence       = 16
+	defaultMinRepeatInterval = 30 * time.Millisecond
+	defaultMaxRepeatInterval = time.Second
+	defaultRepeatInterval    = 100 * time.Millisecond
+	defaultHoldTimeout       = 10 * time.Second
+	defaultMaxTextLength     = 200
+	defaultCharsPerSecond    = 30
+	defaultMaxMacroDelay     = 5 * time.Second
+	defaultMaxMacroDuration  = 10 * time.Second
+)
+
+// Executor turns inbound websocket commands into input events.
+type Executor struct {
+	injector input.Injector
+
+	maxHold           time.Duration
+	maxRepeat         int
+	maxSequence       int
+	minRepeatInterval time.Duration
+	maxRepeatInterval time.Duration
+	holdTimeout       time.Duration
+	maxTextLength     int
+	charInterval      time.Duration
+	maxMacroDelay     time.Duration
+	maxMacroDuration  time.Duration
+
+	held   map[string]*heldKey
+	heldMu sync.Mutex
+
+	macros   map[string][]MacroStep
+	macrosMu sync.Mutex
+}
+
+type heldKey struct {
+	chord input.Chord
+	timer *time.Timer
+}
+
+func NewExecutor(injector input.Injector, cfg Config) *Executor {
+	return &Executor{
+		injector:          injector,
+		maxHold:           defaults.Millis(cfg.MaxHoldMs, defaultMaxHold),
+		maxRepeat:         defaults.Int(cfg.MaxRepeat, defaultMaxRepeat),
+		maxSequence:       defaults.Int(cfg.MaxSequence, defaultMaxSequence),
+		minRepeatInterval: defaults.Millis(cfg.MinRepeatIntervalMs, defaultMinRepeatInterval),
+		maxRepeatInterval: defaults.Millis(cfg.MaxRepeatIntervalMs, defaultMaxRepeatInterval),
+		holdTimeout:       defaults.Millis(cfg.HoldTimeoutMs, defaultHoldTimeout),
+		maxTextLength:     defaults.Int(cfg.MaxTextLength, defaultMaxTextLength),
+		charInterval:      time.Second / time.Duration(defaults.Int(cfg.TypeCharsPerSecond, defaultCharsPerSecond)),
+		maxMacroDelay:     defaults.Millis(cfg.MaxMacroDelayMs, defaultMaxMacroDelay),
+		maxMacroDuration:  defaults.Millis(cfg.MaxMacroDurationMs, defaultMaxMacroDuration),
+		held:              make(map[string]*heldKey),
+	}
+}
+
+func (e *Executor) Injector() input.Injector {
+	return e.injector
+}
+
+// ExecuteKeys runs a keyCode message according to its action. Hold and repeat
+// block until they are done; down returns immediately and leaves the key
+// pressed until the matching up, a ReleaseAll or the hold timeout.
+func (e *Executor) ExecuteKeys(msg websocket.KeyCodeMessage) error {
+	if len(msg.Sequence) > e.maxSequence {
+		return fmt.Errorf("sequence of %d keystrokes exceeds limit of %d", len(msg.Sequence), e.maxSequence)
+	}
+
+	switch msg.Action {
+	case "", websocket.ActionTap:
+		return e.tapStrokes(msg.Strokes())
+	case websocket.ActionHold:
+		return e.hold(msg)
+	case websocket.ActionRepeat:
+		return e.repeat(msg)
+	case websocket.ActionDown:
+		return e.down(msg)
+	case websocket.ActionUp:
+		return e.up(msg)
+	default:
+		return fmt.Errorf("unknown key action: %s", msg.Action)
+	}
+}
+
+// TypeText types msg.Text one character at a time, no faster than the
+// configured characters per second.
+func (e *Executor) TypeText(msg websocket.TypeTextMessage) error {
+	if err := e.checkText(msg.Text); err != nil {
+		return err
+	}
+
+	i := 0
+	for _, r := range msg.Text {
+		if i > 0 {
+			time.Sleep(e.charInterval)
+		}
+		if err := e.injector.TypeText(string(r)); err != nil {
+			return fmt.Errorf("character %d: %v", i, err)
+		}
+		i++
+	}
+	return nil
+}
+
+// checkText rejects text that TypeText would refuse to type.
+func (e *Executor) checkText(text string) error {
+	length := utf8.RuneCountInString(text)
+	if length == 0 {
+		return fmt.Errorf("no text to type")
+	}
+	if length > e.maxTextLength {
+		return fmt.Errorf("text of %d characters exceeds limit of %d", length, e.maxTextLength)
+	}
+	if !utf8.ValidString(text) {
+		return fmt.Errorf("text is not valid UTF-8")
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return fmt.Errorf("text contains control character %U", r)
+		}
+	}
+	return nil
+}
+
+// ReleaseAll releases every key left down by a down action. It is called when
+// the connection drops so that a lost up message never leaves a key stuck.
+func (e *Executor) ReleaseAll() {
+	e.heldMu.Lock()
+	held := e.held
+	e.held = make(map[string]*heldKey)
+	e.heldMu.Unlock()
+
+	for key, h := range held {
+		h.timer.Stop()
+		if err := releaseChord(e.injector, h.chord); err != nil {
+			log.Printf("Error releasing held key %s: %v", key, err)
+		}
+	}
+}
+
+func (e *Executor) tapStrokes(strokes []websocket.KeyStroke) error {
+	for i, stroke := range strokes {
+		if stroke.KeyCode == "" {
+			return fmt.Errorf("keystroke %d has no key code", i)
+		}
+		if err := input.PressChord(e.injector, strokeChord(stroke)); err != nil {
+			return fmt.Errorf("keystroke %d (%s): %v", i, stroke.KeyCode, err)
+		}
+	}
+	return nil
+}
+
+func (e *Executor) hold(msg websocket.KeyCodeMessage) error {
+	chord, err := singleChord(msg)
+	if err != nil {
+		return err
+	}
+
+	duration := time.Duration(msg.DurationMs) * time.Millisecond
+	if duration <= 0 {
+		return fmt.Errorf("hold needs a positive durationMs")
+	}
+	if duration > e.maxHold {
+		return fmt.Errorf("hold of %v exceeds limit of %v", duration, e.maxHold)
+	}
+
+	if err := pressChord(e.injector, chord); err != nil {
+		return err
+	}
+	time.Sleep(duration)
+	return releaseChord(e.injector, chord)
+}
+
+func (e *Executor) repeat(msg websocket.KeyCodeMessage) error {
+	if msg.Count <= 0 {
+		return fmt.Errorf("repeat needs a positive count")
+	}
+	if msg.Count > e.maxRepeat {
+		return fmt.Errorf("repeat count %d exceeds limit of %d", msg.Count, e.maxRepeat)
+	}
+
+	interval := defaultRepeatInterval
+	if msg.IntervalMs > 0 {
+		interval = time.Duration(msg.IntervalMs) * time.Millisecond
+	}
+	interval = min(max(interval, e.minRepeatInterval), e.maxRepeatInterval)
+
+	strokes := msg.Strokes()
+	for i := 0; i < msg.Count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		if err := e.tapStrokes(strokes); err != nil {
+			return fmt.Errorf("repeat %d of %d: %v", i+1, msg.Count, err)
+		}
+	}
+	return nil
+}
+
+func (e *Executor) down(msg websocket.KeyCodeMessage) error {
+	chord, err := singleChord(msg)
+	if err != nil {
+		return err
+	}
+
+	e.heldMu.Lock()
+	defer e.heldMu.Unlock()
+
+	// A repeated down for a key that is already held only extends the
+	// safety timeout, so the server can use it as a keepalive.
+	name := keys.Canonical(chord.Key)
+	if h, ok := e.held[name]; ok {
+		h.timer.Reset(e.holdTimeout)
+		return nil
+	}
+
+	if err := pressChord(e.injector, chord); err != nil {
+		return err
+	}
+
+	h := &heldKey{chord: chord}
+	h.timer = time.AfterFunc(e.holdTimeout, func() {
+		e.heldMu.Lock()
+		current, ok := e.held[name]
+		if ok && current == h {
+			delete(e.held, name)
+		}
+		e.heldMu.Unlock()
+
+		if ok && current == h {
+			log.Printf("No release received for %s within %v, releasing it", chord.Key, e.holdTimeout)
+			if err := releaseChord(e.injector, chord); err != nil {
+				log.Printf("Error releasing held key %s: %v", chord.Key, err)
+			}
+		}
+	})
+	e.held[name] = h
+	return nil
+}
+
+func (e *Executor) up(msg websocket.KeyCodeMessage) error {
+	chord, err := singleChord(msg)
+	if err != nil {
+		return err
+	}
+
+	name := keys.Canonical(chord.Key)
+	e.heldMu.Lock()
+	h, ok := e.held[name]
+	if ok {
+		delete(e.held, name)
+	}
+	e.heldMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("key %s is not held", chord.Key)
+	}
+	h.timer.Stop()
+	return releaseChord(e.injector, h.chord)
+}
+
+func singleChord(msg websocket.KeyCodeMessage) (input.Chord, error) {
+	strokes := msg.Strokes()
+	if len(strokes) != 1 || strokes[0].KeyCode == "" {
+		return input.Chord{}, fmt.Errorf("%s action needs exactly one key", msg.Action)
+	}
+	return strokeChord(strokes[0]), nil
+}
+
+func strokeChord(stroke websocket.KeyStroke) input.Chord {
+	return input.Chord{Key: stroke.KeyCode, Modifiers: stroke.Modifiers}
+}
+
+// pressChord presses the modifiers and then the key, undoing what it pressed
+// if any step fails.
+func pressChord(inj input.Injector, c input.Chord) error {
+	keys := append(append([]string{}, c.Modifiers...), c.Key)
+	for i, key := range keys {
+		if err := inj.Press(key); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				inj.Release(keys[j])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseChord releases the key and then the modifiers in reverse order. It
+// keeps going after a failure so that as much as possible is released.
+func releaseChord(inj input.Injector, c input.Chord) error {
+	err := inj.Release(c.Key)
+	for i := len(c.Modifiers) - 1; i >= 0; i-- {
+		if rerr := inj.Release(c.Modifiers[i]); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package commands
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mediacontrol/pkg/input/inputtest"
+	"mediacontrol/pkg/websocket"
+)
+
+func press(key string) inputtest.Event {
+	return inputtest.Event{Kind: inputtest.EventPress, Key: key}
+}
+
+func release(key string) inputtest.Event {
+	return inputtest.Event{Kind: inputtest.EventRelease, Key: key}
+}
+
+func newTestExecutor(cfg Config) (*Executor, *inputtest.Recorder) {
+	rec := inputtest.NewRecorder()
+	return NewExecutor(rec, cfg), rec
+}
+
+func checkEvents(t *testing.T, rec *inputtest.Recorder, want []inputtest.Event) {
+	t.Helper()
+	got := rec.Events()
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestTap(t *testing.T) {
+	e, rec := newTestExecutor(Config{})
+
+	if err := e.ExecuteKeys(websocket.KeyCodeMessage{KeyCode: "media.play_pause"}); err != nil {
+		t.Fatalf("ExecuteKeys: %v", err)
+	}
+	checkEvents(t, rec, []inputtest.Event{press("media.play_pause"), release("media.play_pause")})
+}
+
+func TestChordOrder(t *testing.T) {
+	e, rec := newTestExecutor(Config{})
+
+	msg := websocket.KeyCodeMessage{KeyCode: "key.m", Modifiers: []string{"mod.ctrl", "mod.shift"}}
+	if err := e.ExecuteKeys(msg); err != nil {
+		t.Fatalf("ExecuteKeys: %v", err)
+	}
+	checkEvents(t, rec, []inputtest.Event{
+		press("mod.ctrl"), press("mod.shift"),
+		press("key.m"), release("key.m"),
+		release("mod.shift"), release("mod.ctrl"),
+	})
+}
+
+func TestSequence(t *testing.T) {
+	e, rec := newTestExecutor(Config{})
+
+	msg := websocket.KeyCodeMessage{Sequence: []websocket.KeyStroke{
+		{KeyCode: "key.a", Modifiers: []string{"mod.ctrl"}},
+		{KeyCode: "key.c", Modifiers: []string{"mod.ctrl"}},
+	}}
+	if err := e.ExecuteKeys(msg); err != nil {
+		t.Fatalf("ExecuteKeys: %v", err)
+	}
+	checkEvents(t, rec, []inputtest.Event{
+		press("mod.ctrl"), press("key.a"), release("key.a"), release("mod.ctrl"),
+		press("mod.ctrl"), press("key.c"), release("key.c"), release("mod.ctrl"),
+	})
+}
+
+func TestSequenceLimit(t *testing.T) {
+	e, rec := newTestExecutor(Config{MaxSequence: 2})
+
+	strokes := []websocket.KeyStroke{{KeyCode: "key.a"}, {KeyCode: "key.b"}, {KeyCode: "key.c"}}
+	for _, action := range []string{"", websocket.ActionRepeat} {
+		err := e.ExecuteKeys(websocket.KeyCodeMessage{Sequence: strokes, Action: action, Count: 1})
+		if err == nil || !strings.Contains(err.Error(), "exceeds limit of 2") {
+			t.Errorf("%q with 3 keystrokes: err = %v, want limit error", action, err)
+		}
+	}
+	checkEvents(t, rec, nil)
+}
+
+func TestHold(t *testing.T) {
+	e, rec := newTestExecutor(Config{MaxHoldMs: 100})
+
+	msg := websocket.KeyCodeMessage{KeyCode: "key.space", Modifiers: []string{"mod.shift"}, Action: websocket.ActionHold, DurationMs: 20}
+	start := time.Now()
+	if err := e.ExecuteKeys(msg); err != nil {
+		t.Fatalf("ExecuteKeys: %v", err)
+	}
+	if held := time.Since(start); held < 20*time.Millisecond {
+		t.Errorf("hold returned after %v, want at least 20ms", held)
+	}
+	checkEvents(t, rec, []inputtest.Event{
+		press("mod.shift"), press("key.space"), release("key.space"), release("mod.shift"),
+	})
+
+	rec.Reset()
+	msg.DurationMs = 101
+	if err := e.ExecuteKeys(msg); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("hold over the cap: err = %v, want limit error", err)
+	}
+	msg.DurationMs = 0
+	if err := e.ExecuteKeys(msg); err == nil {
+		t.Error("hold without duration: want error")
+	}
+	checkEvents(t, rec, nil)
+}
+
+func TestRepeat(t *testing.T) {
+	e, rec := newTestExecutor(Config{MaxRepeat: 3, MinRepeatIntervalMs: 1})
+
+	msg := websocket.KeyCodeMessage{KeyCode: "volume.up", Action: websocket.ActionRepeat, Count: 3, IntervalMs: 1}
+	if err := e.ExecuteKeys(msg); err != nil {
+		t.Fatalf("ExecuteKeys: %v", err)
+	}
+	var want []inputtest.Event
+	for range 3 {
+		want = append(want, press("volume.up"), release("volume.up"))
+	}
+	checkEvents(t, rec, want)
+
+	rec.Reset()
+	msg.Count = 4
+	if err := e.ExecuteKeys(msg); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("repeat over the cap: err = %v, want limit error", err)
+	}
+	msg.Count = 0
+	if err := e.ExecuteKeys(msg); err == nil {
+		t.Error("repeat without count: want error")
+	}
+	checkEvents(t, rec, nil)
+}
+
+func TestDownUp(t *testing.T) {
+	e, rec := newTestExecutor(Config{})
+
+	down := websocket.KeyCodeMessage{KeyCode: "key.w", Modifiers: []string{"mod.shift"}, Action: websocket.ActionDown}
+	if err := e.ExecuteKeys(down); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	// A second down only extends the hold.
+	if err := e.ExecuteKeys(down); err != nil {
+		t.Fatalf("repeated down: %v", err)
+	}
+	up := websocket.KeyCodeMessage{KeyCode: "VK_W", Action: websocket.ActionUp}
+	if err := e.ExecuteKeys(up); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if err := e.ExecuteKeys(up); err == nil {
+		t.Error("up for a key that is not held: want error")
+	}
+	checkEvents(t, rec, []inputtest.Event{
+		press("mod.shift"), press("key.w"), release("key.w"), release("mod.shift"),
+	})
+}
+
+func TestReleaseAll(t *testing.T) {
+	e, rec := newTestExecutor(Config{})
+
+	for _, key := range []string{"key.a", "key.d"} {
+		if err := e.ExecuteKeys(websocket.KeyCodeMessage{KeyCode: key, Action: websocket.ActionDown}); err != nil {
+			t.Fatalf("down %s: %v", key, err)
+		}
+	}
+	rec.Reset()
+
+	e.ReleaseAll()
+	released := map[string]bool{}
+	for _, ev := range rec.Events() {
+		if ev.Kind != inputtest.EventRelease {
+			t.Errorf("unexpected event %v", ev)
+		}
+		released[ev.Key] = true
+	}
+	if !released["key.a"] || !released["key.d"] || len(released) != 2 {
+		t.Errorf("released %v, want key.a and key.d", released)
+	}
+
+	// Nothing is left to release, and the keys are no longer held.
+	rec.Reset()
+	e.ReleaseAll()
+	checkEvents(t, rec, nil)
+	if err := e.ExecuteKeys(websocket.KeyCodeMessage{KeyCode: "key.a", Action: websocket.ActionUp}); err == nil {
+		t.Error("up after ReleaseAll: want error")
+	}
+}
+
+func TestHoldTimeout(t *testing.T) {
+	e, rec := newTestExecutor(Config{HoldTimeoutMs: 20})
+
+	if err := e.ExecuteKeys(websocket.KeyCodeMessage{KeyCode: "key.s", Action: websocket.ActionDown}); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(rec.Events()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	checkEvents(t, rec, []inputtest.Event{press("key.s"), release("key.s")})
+}
+
+func TestTypeText(t *testing.T) {
+	e, rec := newTestExecutor(Config{MaxTextLength: 5, TypeCharsPerSecond: 1000})
+
+	if err := e.TypeText(websocket.TypeTextMessage{Text: "hé!"}); err != nil {
+		t.Fatalf("TypeText: %v", err)
+	}
+	checkEvents(t, rec, []inputtest.Event{
+		{Kind: inputtest.EventTypeText, Text: "h"},
+		{Kind: inputtest.EventTypeText, Text: "é"},
+		{Kind: inputtest.EventTypeText, Text: "!"},
+	})
+
+	rec.Reset()
+	for _, text := range []string{"", "toolong", "a\x1bb"} {
+		if err := e.TypeText(websocket.TypeTextMessage{Text: text}); err == nil {
+			t.Errorf("TypeText(%q): want error", text)
+		}
+	}
+	checkEvents(t, rec, nil)
+}
@@ -0,0 +1,151 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+	"unicode/utf8"
+
+	"mediacontrol/pkg/input"
+	"mediacontrol/pkg/keys"
+	"mediacontrol/pkg/websocket"
+)
+
+// MacroStep is one step of a macro from config.yaml. Exactly one of Tap,
+// Chord (modifiers first, key last), DelayMs, Type or Launch must be set;
+// Args only goes with Launch.
+type MacroStep struct {
+	Tap     string   `yaml:"tap,omitempty"`
+	Chord   []string `yaml:"chord,omitempty"`
+	DelayMs int      `yaml:"delay_ms,omitempty"`
+	Type    string   `yaml:"type,omitempty"`
+	Launch  string   `yaml:"launch,omitempty"`
+	Args    []string `yaml:"args,omitempty"`
+}
+
+func (s MacroStep) validate() error {
+	set := 0
+	if s.Tap != "" {
+		set++
+	}
+	if len(s.Chord) > 0 {
+		set++
+	}
+	if s.DelayMs != 0 {
+		set++
+	}
+	if s.Type != "" {
+		set++
+	}
+	if s.Launch != "" {
+		set++
+	}
+
+	if set != 1 {
+		return fmt.Errorf("step must set exactly one of tap, chord, delay_ms, type or launch")
+	}
+	if s.DelayMs < 0 {
+		return fmt.Errorf("delay_ms must be positive")
+	}
+	if len(s.Args) > 0 && s.Launch == "" {
+		return fmt.Errorf("args is only allowed with launch")
+	}
+	if s.Tap != "" {
+		if err := keys.Validate(s.Tap); err != nil {
+			return err
+		}
+	}
+	for _, key := range s.Chord {
+		if err := keys.Validate(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMacros replaces the macros that RunMacro can run, after checking every
+// step and that no macro runs longer than the configured caps.
+func (e *Executor) SetMacros(macros map[string][]MacroStep) error {
+	for name, steps := range macros {
+		if len(steps) == 0 {
+			return fmt.Errorf("macro %s has no steps", name)
+		}
+		var duration time.Duration
+		for i, step := range steps {
+			if err := step.validate(); err != nil {
+				return fmt.Errorf("macro %s step %d: %v", name, i+1, err)
+			}
+			delay := time.Duration(step.DelayMs) * time.Millisecond
+			if delay > e.maxMacroDelay {
+				return fmt.Errorf("macro %s step %d: delay of %v exceeds limit of %v", name, i+1, delay, e.maxMacroDelay)
+			}
+			duration += delay
+			if step.Type != "" {
+				if err := e.checkText(step.Type); err != nil {
+					return fmt.Errorf("macro %s step %d: %v", name, i+1, err)
+				}
+				duration += time.Duration(utf8.RuneCountInString(step.Type)-1) * e.charInterval
+			}
+		}
+		if duration > e.maxMacroDuration {
+			return fmt.Errorf("macro %s takes %v, which exceeds limit of %v", name, duration, e.maxMacroDuration)
+		}
+	}
+
+	e.macrosMu.Lock()
+	e.macros = macros
+	e.macrosMu.Unlock()
+	return nil
+}
+
+// RunMacro runs the steps of the named macro in order, stopping at the first
+// failure.
+func (e *Executor) RunMacro(name string) error {
+	e.macrosMu.Lock()
+	steps, ok := e.macros[name]
+	e.macrosMu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown macro: %s", name)
+	}
+
+	for i, step := range steps {
+		if err := e.runMacroStep(step); err != nil {
+			return fmt.Errorf("macro %s step %d: %v", name, i+1, err)
+		}
+	}
+	return nil
+}
+
+func (e *Executor) runMacroStep(step MacroStep) error {
+	switch {
+	case step.Tap != "":
+		return input.PressChord(e.injector, input.Chord{Key: step.Tap})
+	case len(step.Chord) > 0:
+		last := len(step.Chord) - 1
+		return input.PressChord(e.injector, input.Chord{Key: step.Chord[last], Modifiers: step.Chord[:last]})
+	case step.DelayMs > 0:
+		time.Sleep(time.Duration(step.DelayMs) * time.Millisecond)
+		return nil
+	case step.Type != "":
+		return e.TypeText(websocket.TypeTextMessage{Text: step.Type})
+	case step.Launch != "":
+		return launch(step.Launch, step.Args)
+	}
+	return nil
+}
+
+func launch(program string, args []string) error {
+	cmd := exec.Command(program, args...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error launching %s: %v", program, err)
+	}
+
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("Launched program %s exited with error: %v", program, err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"mediacontrol/pkg/input/inputtest"
+)
+
+func TestRunMacro(t *testing.T) {
+	e, rec := newTestExecutor(Config{TypeCharsPerSecond: 1000})
+
+	err := e.SetMacros(map[string][]MacroStep{
+		"focus_and_skip": {
+			{Chord: []string{"mod.alt", "key.tab"}},
+			{DelayMs: 1},
+			{Tap: "media.next"},
+			{Type: "ok"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetMacros: %v", err)
+	}
+	if err := e.RunMacro("focus_and_skip"); err != nil {
+		t.Fatalf("RunMacro: %v", err)
+	}
+	checkEvents(t, rec, []inputtest.Event{
+		press("mod.alt"), press("key.tab"), release("key.tab"), release("mod.alt"),
+		press("media.next"), release("media.next"),
+		{Kind: inputtest.EventTypeText, Text: "o"},
+		{Kind: inputtest.EventTypeText, Text: "k"},
+	})
+
+	if err := e.RunMacro("missing"); err == nil {
+		t.Error("RunMacro(missing): want error")
+	}
+}
+
+func TestSetMacrosRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []MacroStep
+		want  string
+	}{
+		{"empty", nil, "no steps"},
+		{"two actions", []MacroStep{{Tap: "key.a", DelayMs: 10}}, "exactly one"},
+		{"negative delay", []MacroStep{{DelayMs: -1}}, "positive"},
+		{"stray args", []MacroStep{{Tap: "key.a", Args: []string{"x"}}}, "only allowed with launch"},
+		{"unknown tap", []MacroStep{{Tap: "key.nope"}}, "unknown key"},
+		{"unknown chord key", []MacroStep{{Chord: []string{"mod.ctrl", "key.nope"}}}, "unknown key"},
+		{"long delay", []MacroStep{{DelayMs: 501}}, "exceeds limit"},
+		{"long macro", []MacroStep{{DelayMs: 400}, {DelayMs: 400}, {DelayMs: 400}}, "exceeds limit"},
+		{"long typing", []MacroStep{{Type: strings.Repeat("a", 12)}}, "exceeds limit"},
+		{"long text", []MacroStep{{Type: strings.Repeat("a", 300)}}, "exceeds limit of 200"},
+		{"control character", []MacroStep{{Type: "a\x07b"}}, "control character U+0007"},
+		{"invalid UTF-8", []MacroStep{{Type: "a\xffb"}}, "not valid UTF-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _ := newTestExecutor(Config{MaxMacroDelayMs: 500, MaxMacroDurationMs: 1000, TypeCharsPerSecond: 10})
+			err := e.SetMacros(map[string][]MacroStep{"m": tt.steps})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("SetMacros = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
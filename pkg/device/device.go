@@ -0,0 +1,103 @@
+package device
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Config struct {
+	// File stores the device ID and name. It is created on first run.
+	File string `yaml:"file"`
+}
+
+const defaultFile = "device.json"
+
+// Path returns the identity file, defaulting to device.json.
+func (c Config) Path() string {
+	if c.File == "" {
+		return defaultFile
+	}
+	return c.File
+}
+
+// Identity tells this install apart from the user's other desktops.
+type Identity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+const maxNameLength = 64
+
+// LoadOrCreate reads the identity from path, or generates a new ID named
+// after the host and saves it if the file does not exist yet.
+func LoadOrCreate(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var identity Identity
+		if err := json.Unmarshal(data, &identity); err != nil {
+			return nil, fmt.Errorf("error reading device file %s: %v", path, err)
+		}
+		if identity.ID == "" {
+			return nil, fmt.Errorf("device file %s has no ID", path)
+		}
+		return &identity, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		name = "Desktop"
+	}
+	identity := &Identity{ID: id, Name: name}
+	if err := identity.Save(path); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (i *Identity) Save(path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(i, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// CleanName trims a user-entered device name and checks it is usable.
+func CleanName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("device name is empty")
+	}
+	if len([]rune(name)) > maxNameLength {
+		return "", fmt.Errorf("device name is longer than %d characters", maxNameLength)
+	}
+	return name, nil
+}
+
+// newID returns a random (version 4) UUID.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating device ID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
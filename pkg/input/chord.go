@@ -0,0 +1,58 @@
+package input
+
+// Chord is a key pressed while holding zero or more modifiers, e.g.
+// Ctrl+Shift+M is Chord{Key: "key.m", Modifiers: []string{"mod.ctrl", "mod.shift"}}.
+type Chord struct {
+	Key       string
+	Modifiers []string
+}
+
+// ChordInjector is implemented by backends that can emit a whole chord at
+// once, so that no other input can be interleaved between its events.
+type ChordInjector interface {
+	Chord(c Chord) error
+}
+
+// PressChord presses the modifiers in order, taps the key, then releases the
+// modifiers in reverse order. Modifiers that were pressed are always released,
+// even if a later step fails.
+func PressChord(inj Injector, c Chord) error {
+	if ci, ok := inj.(ChordInjector); ok {
+		return ci.Chord(c)
+	}
+
+	var pressed []string
+	defer func() {
+		for i := len(pressed) - 1; i >= 0; i-- {
+			inj.Release(pressed[i])
+		}
+	}()
+
+	for _, mod := range c.Modifiers {
+		if err := inj.Press(mod); err != nil {
+			return err
+		}
+		pressed = append(pressed, mod)
+	}
+
+	return inj.Tap(c.Key)
+}
+
+type keyTransition struct {
+	Key  string
+	Down bool
+}
+
+// chordTransitions returns the key transitions for c in the order they must
+// be sent.
+func chordTransitions(c Chord) []keyTransition {
+	var steps []keyTransition
+	for _, mod := range c.Modifiers {
+		steps = append(steps, keyTransition{Key: mod, Down: true})
+	}
+	steps = append(steps, keyTransition{Key: c.Key, Down: true}, keyTransition{Key: c.Key, Down: false})
+	for i := len(c.Modifiers) - 1; i >= 0; i-- {
+		steps = append(steps, keyTransition{Key: c.Modifiers[i], Down: false})
+	}
+	return steps
+}
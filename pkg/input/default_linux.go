@@ -0,0 +1,7 @@
+package input
+
+const DefaultBackend = "uinput"
+
+// FallbackBackend is used when the default backend cannot be created, e.g.
+// without write access to /dev/uinput. It only handles media keys.
+const FallbackBackend = "mpris"
@@ -0,0 +1,8 @@
+//go:build !windows && !linux
+
+package input
+
+// DefaultBackend is empty: there is no input backend for this platform.
+const DefaultBackend = ""
+
+const FallbackBackend = ""
@@ -0,0 +1,5 @@
+package input
+
+const DefaultBackend = "sendinput"
+
+const FallbackBackend = ""
@@ -0,0 +1,87 @@
+package input
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Injector is implemented by every input backend. Keys are identified by any
+// name keys.Lookup accepts, e.g. "media.play_pause" or "VK_MEDIA_PLAY_PAUSE".
+type Injector interface {
+	Name() string
+	Press(key string) error
+	Release(key string) error
+	Tap(key string) error
+	TypeText(text string) error
+	Close() error
+}
+
+type Factory func() (Injector, error)
+
+var ErrUnsupported = errors.New("not supported by this input backend")
+
+// ErrNoBackend is returned by New for the platform default on platforms
+// without an input backend.
+var ErrNoBackend = errors.New("no input backend for this platform")
+
+var (
+	backends   = make(map[string]Factory)
+	backendsMu sync.Mutex
+)
+
+func Register(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	backends[name] = factory
+}
+
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the named backend. An empty name selects the platform default.
+func New(name string) (Injector, error) {
+	if name == "" {
+		if DefaultBackend == "" {
+			return nil, fmt.Errorf("%w (available: %v)", ErrNoBackend, Backends())
+		}
+		name = DefaultBackend
+	}
+
+	backendsMu.Lock()
+	factory, ok := backends[name]
+	backendsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown input backend %q (available: %v)", name, Backends())
+	}
+	return factory()
+}
+
+// Unavailable returns an Injector that fails every call with err, for running
+// without a working backend.
+func Unavailable(err error) Injector {
+	return unavailable{err: err}
+}
+
+type unavailable struct {
+	err error
+}
+
+func (u unavailable) Name() string               { return "none" }
+func (u unavailable) Press(key string) error     { return u.err }
+func (u unavailable) Release(key string) error   { return u.err }
+func (u unavailable) Tap(key string) error       { return u.err }
+func (u unavailable) TypeText(text string) error { return u.err }
+func (u unavailable) Close() error               { return nil }
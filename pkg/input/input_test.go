@@ -0,0 +1,30 @@
+package input
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewUnknown(t *testing.T) {
+	if _, err := New("nope"); err == nil {
+		t.Error("New(nope): want error")
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	cause := errors.New("permission denied")
+	inj := Unavailable(cause)
+	if inj.Name() != "none" {
+		t.Errorf("Name() = %q, want none", inj.Name())
+	}
+	for name, err := range map[string]error{
+		"Press":    inj.Press("media.next"),
+		"Release":  inj.Release("media.next"),
+		"Tap":      inj.Tap("media.next"),
+		"TypeText": inj.TypeText("a"),
+	} {
+		if !errors.Is(err, cause) {
+			t.Errorf("%s = %v, want %v", name, err, cause)
+		}
+	}
+}
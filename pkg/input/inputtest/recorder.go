@@ -0,0 +1,80 @@
+// Package inputtest provides an input.Injector that records what it is asked
+// to do, for testing code that injects input without touching the desktop.
+package inputtest
+
+import "sync"
+
+type EventKind string
+
+const (
+	EventPress    EventKind = "press"
+	EventRelease  EventKind = "release"
+	EventTypeText EventKind = "type"
+)
+
+type Event struct {
+	Kind EventKind
+	Key  string
+	Text string
+}
+
+// Recorder is an input.Injector that only records what it was asked to do.
+type Recorder struct {
+	events []Event
+	mu     sync.Mutex
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Name() string {
+	return "recorder"
+}
+
+func (r *Recorder) Press(key string) error {
+	r.record(Event{Kind: EventPress, Key: key})
+	return nil
+}
+
+func (r *Recorder) Release(key string) error {
+	r.record(Event{Kind: EventRelease, Key: key})
+	return nil
+}
+
+func (r *Recorder) Tap(key string) error {
+	r.Press(key)
+	return r.Release(key)
+}
+
+func (r *Recorder) TypeText(text string) error {
+	r.record(Event{Kind: EventTypeText, Text: text})
+	return nil
+}
+
+func (r *Recorder) Close() error {
+	return nil
+}
+
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]Event, len(r.events))
+	copy(events, r.events)
+	return events
+}
+
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = nil
+}
+
+func (r *Recorder) record(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, e)
+}
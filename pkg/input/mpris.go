@@ -0,0 +1,201 @@
+package input
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"mediacontrol/pkg/keys"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	mprisBusPrefix  = "org.mpris.MediaPlayer2."
+	mprisObjectPath = "/org/mpris/MediaPlayer2"
+	mprisPlayer     = "org.mpris.MediaPlayer2.Player"
+
+	mprisVolumeStep = 0.05
+)
+
+// MPRIS controls media players over the D-Bus MPRIS interface instead of
+// faking keypresses. Only media and volume keys are supported.
+type MPRIS struct {
+	conn       *dbus.Conn
+	ownsConn   bool
+	mutedLevel map[string]float64
+	mu         sync.Mutex
+}
+
+func init() {
+	Register("mpris", func() (Injector, error) {
+		conn, err := dbus.ConnectSessionBus()
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to session bus: %v", err)
+		}
+		m := NewMPRIS(conn)
+		m.ownsConn = true
+		return m, nil
+	})
+}
+
+// NewMPRIS uses an existing bus connection, which lets tests point the
+// backend at a private dbus-daemon. The caller keeps ownership of conn.
+func NewMPRIS(conn *dbus.Conn) *MPRIS {
+	return &MPRIS{
+		conn:       conn,
+		mutedLevel: make(map[string]float64),
+	}
+}
+
+func (m *MPRIS) Name() string {
+	return "mpris"
+}
+
+// Press runs the player action; media keys have no meaningful held state.
+func (m *MPRIS) Press(key string) error {
+	return m.Tap(key)
+}
+
+func (m *MPRIS) Release(key string) error {
+	if _, ok := mprisActions[keys.Canonical(key)]; !ok {
+		return fmt.Errorf("key %s: %w", key, ErrUnsupported)
+	}
+	return nil
+}
+
+func (m *MPRIS) Tap(key string) error {
+	action, ok := mprisActions[keys.Canonical(key)]
+	if !ok {
+		return fmt.Errorf("key %s: %w", key, ErrUnsupported)
+	}
+
+	player, err := m.ActivePlayer()
+	if err != nil {
+		return err
+	}
+	return action(m, player)
+}
+
+func (m *MPRIS) TypeText(text string) error {
+	return ErrUnsupported
+}
+
+func (m *MPRIS) Close() error {
+	if m.ownsConn {
+		return m.conn.Close()
+	}
+	return nil
+}
+
+// ActivePlayer returns the bus name of the player to control: the first one
+// that is currently playing, otherwise the first one found.
+func (m *MPRIS) ActivePlayer() (string, error) {
+	var names []string
+	if err := m.conn.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names); err != nil {
+		return "", fmt.Errorf("error listing bus names: %v", err)
+	}
+
+	var players []string
+	for _, name := range names {
+		if strings.HasPrefix(name, mprisBusPrefix) {
+			players = append(players, name)
+		}
+	}
+	if len(players) == 0 {
+		return "", fmt.Errorf("no MPRIS media player found")
+	}
+
+	for _, player := range players {
+		status, err := m.object(player).GetProperty(mprisPlayer + ".PlaybackStatus")
+		if err == nil && status.Value() == "Playing" {
+			return player, nil
+		}
+	}
+	return players[0], nil
+}
+
+func (m *MPRIS) object(player string) dbus.BusObject {
+	return m.conn.Object(player, mprisObjectPath)
+}
+
+func (m *MPRIS) call(player, method string) error {
+	if err := m.object(player).Call(mprisPlayer+"."+method, 0).Err; err != nil {
+		return fmt.Errorf("error calling %s on %s: %v", method, player, err)
+	}
+	return nil
+}
+
+func (m *MPRIS) volume(player string) (float64, error) {
+	v, err := m.object(player).GetProperty(mprisPlayer + ".Volume")
+	if err != nil {
+		return 0, fmt.Errorf("error reading volume of %s: %v", player, err)
+	}
+	volume, ok := v.Value().(float64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected volume type %s from %s", v.Signature(), player)
+	}
+	return volume, nil
+}
+
+func (m *MPRIS) setVolume(player string, volume float64) error {
+	volume = min(max(volume, 0), 1)
+	if err := m.object(player).SetProperty(mprisPlayer+".Volume", dbus.MakeVariant(volume)); err != nil {
+		return fmt.Errorf("error setting volume of %s: %v", player, err)
+	}
+	return nil
+}
+
+func (m *MPRIS) stepVolume(player string, delta float64) error {
+	volume, err := m.volume(player)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	delete(m.mutedLevel, player)
+	m.mu.Unlock()
+
+	return m.setVolume(player, volume+delta)
+}
+
+// toggleMute sets the volume to zero and remembers the previous level so the
+// next mute restores it. MPRIS has no mute property of its own.
+func (m *MPRIS) toggleMute(player string) error {
+	m.mu.Lock()
+	previous, muted := m.mutedLevel[player]
+	m.mu.Unlock()
+
+	if muted {
+		if err := m.setVolume(player, previous); err != nil {
+			return err
+		}
+		m.mu.Lock()
+		delete(m.mutedLevel, player)
+		m.mu.Unlock()
+		return nil
+	}
+
+	volume, err := m.volume(player)
+	if err != nil {
+		return err
+	}
+	if err := m.setVolume(player, 0); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.mutedLevel[player] = volume
+	m.mu.Unlock()
+	return nil
+}
+
+var mprisActions = map[string]func(m *MPRIS, player string) error{
+	"media.play_pause": func(m *MPRIS, player string) error { return m.call(player, "PlayPause") },
+	"media.next":       func(m *MPRIS, player string) error { return m.call(player, "Next") },
+	"media.previous":   func(m *MPRIS, player string) error { return m.call(player, "Previous") },
+	"media.stop":       func(m *MPRIS, player string) error { return m.call(player, "Stop") },
+	"media.play":       func(m *MPRIS, player string) error { return m.call(player, "Play") },
+	"volume.up":        func(m *MPRIS, player string) error { return m.stepVolume(player, mprisVolumeStep) },
+	"volume.down":      func(m *MPRIS, player string) error { return m.stepVolume(player, -mprisVolumeStep) },
+	"volume.mute":      func(m *MPRIS, player string) error { return m.toggleMute(player) },
+}
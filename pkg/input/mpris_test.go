@@ -0,0 +1,185 @@
+package input
+
+import (
+	"bufio"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// startBus runs a private dbus-daemon for the test and returns its address.
+func startBus(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not available")
+	}
+
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("cannot start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading dbus-daemon address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func connectBus(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("connecting to %s: %v", address, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// fakePlayer is an MPRIS player that records the methods called on it.
+type fakePlayer struct {
+	props *prop.Properties
+	calls []string
+	mu    sync.Mutex
+}
+
+func (p *fakePlayer) record(method string) *dbus.Error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls = append(p.calls, method)
+	return nil
+}
+
+func (p *fakePlayer) PlayPause() *dbus.Error { return p.record("PlayPause") }
+func (p *fakePlayer) Play() *dbus.Error      { return p.record("Play") }
+func (p *fakePlayer) Stop() *dbus.Error      { return p.record("Stop") }
+func (p *fakePlayer) Next() *dbus.Error      { return p.record("Next") }
+func (p *fakePlayer) Previous() *dbus.Error  { return p.record("Previous") }
+
+func (p *fakePlayer) Calls() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.calls...)
+}
+
+func (p *fakePlayer) Volume() float64 {
+	return p.props.GetMust(mprisPlayer, "Volume").(float64)
+}
+
+// startPlayer claims org.mpris.MediaPlayer2.<name> on the bus with a fake
+// player in the given playback status.
+func startPlayer(t *testing.T, address, name, status string) *fakePlayer {
+	t.Helper()
+	conn := connectBus(t, address)
+
+	player := &fakePlayer{}
+	if err := conn.Export(player, mprisObjectPath, mprisPlayer); err != nil {
+		t.Fatal(err)
+	}
+	props, err := prop.Export(conn, mprisObjectPath, prop.Map{
+		mprisPlayer: {
+			"PlaybackStatus": {Value: status, Emit: prop.EmitTrue},
+			"Volume":         {Value: 0.5, Writable: true, Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	player.props = props
+
+	reply, err := conn.RequestName(mprisBusPrefix+name, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("claiming %s: reply %v, err %v", name, reply, err)
+	}
+	return player
+}
+
+func TestMPRISMediaKeys(t *testing.T) {
+	address := startBus(t)
+	player := startPlayer(t, address, "fake", "Paused")
+	m := NewMPRIS(connectBus(t, address))
+
+	for _, key := range []string{"media.play_pause", "VK_MEDIA_NEXT_TRACK", "media.previous", "media.stop", "media.play"} {
+		if err := m.Tap(key); err != nil {
+			t.Fatalf("Tap(%s): %v", key, err)
+		}
+	}
+	want := []string{"PlayPause", "Next", "Previous", "Stop", "Play"}
+	if got := player.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+
+	if err := m.Tap("key.a"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Tap(key.a) = %v, want ErrUnsupported", err)
+	}
+	if err := m.TypeText("a"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("TypeText = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestMPRISVolume(t *testing.T) {
+	address := startBus(t)
+	player := startPlayer(t, address, "fake", "Playing")
+	m := NewMPRIS(connectBus(t, address))
+
+	steps := []struct {
+		key  string
+		want float64
+	}{
+		{"volume.up", 0.55},
+		{"volume.down", 0.5},
+		{"volume.mute", 0},
+		{"volume.mute", 0.5},
+	}
+	for _, step := range steps {
+		if err := m.Tap(step.key); err != nil {
+			t.Fatalf("Tap(%s): %v", step.key, err)
+		}
+		if got := player.Volume(); got < step.want-1e-9 || got > step.want+1e-9 {
+			t.Errorf("after %s volume = %v, want %v", step.key, got, step.want)
+		}
+	}
+}
+
+func TestMPRISActivePlayer(t *testing.T) {
+	address := startBus(t)
+	m := NewMPRIS(connectBus(t, address))
+
+	if _, err := m.ActivePlayer(); err == nil {
+		t.Error("ActivePlayer without players: want error")
+	}
+
+	startPlayer(t, address, "paused", "Paused")
+	playing := startPlayer(t, address, "playing", "Playing")
+
+	got, err := m.ActivePlayer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != mprisBusPrefix+"playing" {
+		t.Errorf("ActivePlayer = %s, want the playing player", got)
+	}
+
+	if err := m.Tap("media.next"); err != nil {
+		t.Fatal(err)
+	}
+	if calls := playing.Calls(); !reflect.DeepEqual(calls, []string{"Next"}) {
+		t.Errorf("playing player calls = %v, want [Next]", calls)
+	}
+}
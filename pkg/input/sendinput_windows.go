@@ -0,0 +1,155 @@
+package input
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"mediacontrol/pkg/keys"
+)
+
+const (
+	inputKeyboard = 1
+
+	keyeventfKeyUp   = 0x0002
+	keyeventfUnicode = 0x0004
+)
+
+var (
+	user32        = syscall.NewLazyDLL("user32.dll")
+	sendInputProc = user32.NewProc("SendInput")
+)
+
+type keyboardInput struct {
+	wVk         uint16
+	wScan       uint16
+	dwFlags     uint32
+	time        uint32
+	dwExtraInfo uint64
+}
+
+type sendInputEvent struct {
+	inputType uint32
+	ki        keyboardInput
+	padding   uint64
+}
+
+// SendInput injects keyboard events through user32.dll's SendInput.
+type SendInput struct{}
+
+func init() {
+	Register("sendinput", func() (Injector, error) {
+		return NewSendInput()
+	})
+}
+
+func NewSendInput() (*SendInput, error) {
+	if err := sendInputProc.Find(); err != nil {
+		return nil, err
+	}
+	return &SendInput{}, nil
+}
+
+func (s *SendInput) Name() string {
+	return "sendinput"
+}
+
+func (s *SendInput) Press(key string) error {
+	code, err := lookupVirtualKey(key)
+	if err != nil {
+		return err
+	}
+	return sendInputs([]sendInputEvent{keyEvent(code, 0)})
+}
+
+func (s *SendInput) Release(key string) error {
+	code, err := lookupVirtualKey(key)
+	if err != nil {
+		return err
+	}
+	return sendInputs([]sendInputEvent{keyEvent(code, keyeventfKeyUp)})
+}
+
+func (s *SendInput) Tap(key string) error {
+	code, err := lookupVirtualKey(key)
+	if err != nil {
+		return err
+	}
+	return sendInputs([]sendInputEvent{
+		keyEvent(code, 0),
+		keyEvent(code, keyeventfKeyUp),
+	})
+}
+
+func (s *SendInput) Chord(c Chord) error {
+	var inputs []sendInputEvent
+	for _, step := range chordTransitions(c) {
+		code, err := lookupVirtualKey(step.Key)
+		if err != nil {
+			return err
+		}
+		var flags uint32
+		if !step.Down {
+			flags = keyeventfKeyUp
+		}
+		inputs = append(inputs, keyEvent(code, flags))
+	}
+	return sendInputs(inputs)
+}
+
+func (s *SendInput) TypeText(text string) error {
+	var inputs []sendInputEvent
+	for _, unit := range syscall.StringToUTF16(text) {
+		if unit == 0 {
+			continue
+		}
+		inputs = append(inputs,
+			unicodeEvent(unit, keyeventfUnicode),
+			unicodeEvent(unit, keyeventfUnicode|keyeventfKeyUp),
+		)
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
+	return sendInputs(inputs)
+}
+
+func (s *SendInput) Close() error {
+	return nil
+}
+
+func lookupVirtualKey(key string) (uint16, error) {
+	k, ok := keys.Lookup(key)
+	if !ok {
+		return 0, fmt.Errorf("unknown key: %s", key)
+	}
+	return k.VK, nil
+}
+
+func keyEvent(code uint16, flags uint32) sendInputEvent {
+	var i sendInputEvent
+	i.inputType = inputKeyboard
+	i.ki.wVk = code
+	i.ki.dwFlags = flags
+	return i
+}
+
+func unicodeEvent(unit uint16, flags uint32) sendInputEvent {
+	var i sendInputEvent
+	i.inputType = inputKeyboard
+	i.ki.wScan = unit
+	i.ki.dwFlags = flags
+	return i
+}
+
+func sendInputs(inputs []sendInputEvent) error {
+	ret, _, err := sendInputProc.Call(
+		uintptr(len(inputs)),
+		uintptr(unsafe.Pointer(&inputs[0])),
+		uintptr(unsafe.Sizeof(inputs[0])),
+	)
+	if int(ret) != len(inputs) {
+		return fmt.Errorf("SendInput inserted %d of %d events: %v", ret, len(inputs), err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package input
+
+import "fmt"
+
+// textKey is the key (and whether Shift is needed) that produces a character
+// on a US keyboard layout.
+type textKey struct {
+	Key   string
+	Shift bool
+}
+
+var shiftedPunctuation = map[rune]textKey{
+	'!': {"key.1", true}, '@': {"key.2", true}, '#': {"key.3", true},
+	'$': {"key.4", true}, '%': {"key.5", true}, '^': {"key.6", true},
+	'&': {"key.7", true}, '*': {"key.8", true}, '(': {"key.9", true},
+	')': {"key.0", true}, '_': {"key.minus", true}, '+': {"key.equal", true},
+	'{': {"key.left_bracket", true}, '}': {"key.right_bracket", true}, '|': {"key.backslash", true},
+	':': {"key.semicolon", true}, '"': {"key.apostrophe", true}, '~': {"key.grave", true},
+	'<': {"key.comma", true}, '>': {"key.period", true}, '?': {"key.slash", true},
+}
+
+var plainPunctuation = map[rune]textKey{
+	' ': {"key.space", false}, '\n': {"key.enter", false}, '\t': {"key.tab", false},
+	'-': {"key.minus", false}, '=': {"key.equal", false}, '[': {"key.left_bracket", false},
+	']': {"key.right_bracket", false}, '\\': {"key.backslash", false}, ';': {"key.semicolon", false},
+	'\'': {"key.apostrophe", false}, '`': {"key.grave", false}, ',': {"key.comma", false},
+	'.': {"key.period", false}, '/': {"key.slash", false},
+}
+
+// asciiTextKey returns the key for r, or false if r has no key on a US layout.
+func asciiTextKey(r rune) (textKey, bool) {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return textKey{Key: fmt.Sprintf("key.%c", r)}, true
+	case r >= 'A' && r <= 'Z':
+		return textKey{Key: fmt.Sprintf("key.%c", r-'A'+'a'), Shift: true}, true
+	case r >= '0' && r <= '9':
+		return textKey{Key: fmt.Sprintf("key.%c", r)}, true
+	}
+	if k, ok := plainPunctuation[r]; ok {
+		return k, true
+	}
+	k, ok := shiftedPunctuation[r]
+	return k, ok
+}
+
+// unicodeInputChords returns the chords that enter r through the Ctrl+Shift+U
+// hex input understood by GTK and IBus, for characters with no key of their own.
+func unicodeInputChords(r rune) []Chord {
+	chords := []Chord{{Key: "key.u", Modifiers: []string{"mod.lctrl", "mod.lshift"}}}
+	for _, digit := range fmt.Sprintf("%x", r) {
+		k, _ := asciiTextKey(digit)
+		chords = append(chords, Chord{Key: k.Key})
+	}
+	return append(chords, Chord{Key: "key.space"})
+}
+
+// textChords returns the chords that type text on a backend that can only
+// press keys.
+func textChords(text string) []Chord {
+	var chords []Chord
+	for _, r := range text {
+		k, ok := asciiTextKey(r)
+		if !ok {
+			chords = append(chords, unicodeInputChords(r)...)
+			continue
+		}
+		c := Chord{Key: k.Key}
+		if k.Shift {
+			c.Modifiers = []string{"mod.lshift"}
+		}
+		chords = append(chords, c)
+	}
+	return chords
+}
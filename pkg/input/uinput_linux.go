@@ -0,0 +1,233 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"mediacontrol/pkg/keys"
+)
+
+const (
+	uinputPath = "/dev/uinput"
+	uinputName = "Audara virtual keyboard"
+
+	uiSetEvBit   = 0x40045564 // _IOW('U', 100, int)
+	uiSetKeyBit  = 0x40045565 // _IOW('U', 101, int)
+	uiDevCreate  = 0x5501     // _IO('U', 1)
+	uiDevDestroy = 0x5502     // _IO('U', 2)
+
+	evSyn     = 0x00
+	evKey     = 0x01
+	synReport = 0
+
+	busVirtual = 0x06
+
+	absCount = 64
+)
+
+type uinputUserDev struct {
+	Name         [80]byte
+	BusType      uint16
+	Vendor       uint16
+	Product      uint16
+	Version      uint16
+	FFEffectsMax uint32
+	AbsMax       [absCount]int32
+	AbsMin       [absCount]int32
+	AbsFuzz      [absCount]int32
+	AbsFlat      [absCount]int32
+}
+
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// UInput injects key events through a virtual keyboard created on /dev/uinput.
+// The user needs write access to /dev/uinput (usually the "input" group or a
+// udev rule).
+type UInput struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+func init() {
+	Register("uinput", func() (Injector, error) {
+		return NewUInput()
+	})
+}
+
+func NewUInput() (*UInput, error) {
+	file, err := os.OpenFile(uinputPath, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", uinputPath, err)
+	}
+
+	if err := setupUInputDevice(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Give udev and the desktop session a moment to pick up the new device,
+	// otherwise the first events are silently dropped.
+	time.Sleep(200 * time.Millisecond)
+
+	return &UInput{file: file}, nil
+}
+
+func setupUInputDevice(file *os.File) error {
+	if err := ioctl(file, uiSetEvBit, evKey); err != nil {
+		return fmt.Errorf("error enabling key events: %v", err)
+	}
+
+	registered := make(map[uint16]bool)
+	for _, k := range keys.All() {
+		if k.Evdev == 0 || registered[k.Evdev] {
+			continue
+		}
+		registered[k.Evdev] = true
+		if err := ioctl(file, uiSetKeyBit, uintptr(k.Evdev)); err != nil {
+			return fmt.Errorf("error enabling key %s: %v", k.Name, err)
+		}
+	}
+
+	dev := uinputUserDev{
+		BusType: busVirtual,
+		Vendor:  0x1,
+		Product: 0x1,
+		Version: 1,
+	}
+	copy(dev.Name[:], uinputName)
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.NativeEndian, &dev); err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing device description: %v", err)
+	}
+
+	if err := ioctl(file, uiDevCreate, 0); err != nil {
+		return fmt.Errorf("error creating virtual keyboard: %v", err)
+	}
+	return nil
+}
+
+func (u *UInput) Name() string {
+	return "uinput"
+}
+
+func (u *UInput) Press(key string) error {
+	code, err := lookupEvdevKey(key)
+	if err != nil {
+		return err
+	}
+	return u.emit(keyInputEvent(code, 1))
+}
+
+func (u *UInput) Release(key string) error {
+	code, err := lookupEvdevKey(key)
+	if err != nil {
+		return err
+	}
+	return u.emit(keyInputEvent(code, 0))
+}
+
+func (u *UInput) Tap(key string) error {
+	code, err := lookupEvdevKey(key)
+	if err != nil {
+		return err
+	}
+	return u.emit(keyInputEvent(code, 1), keyInputEvent(code, 0))
+}
+
+func (u *UInput) Chord(c Chord) error {
+	var events []inputEvent
+	for _, step := range chordTransitions(c) {
+		code, err := lookupEvdevKey(step.Key)
+		if err != nil {
+			return err
+		}
+		var value int32
+		if step.Down {
+			value = 1
+		}
+		events = append(events, keyInputEvent(code, value))
+	}
+	return u.emit(events...)
+}
+
+// TypeText types ASCII through the matching keys of a US layout and anything
+// else through Ctrl+Shift+U hex input, which needs a GTK or IBus focused window.
+func (u *UInput) TypeText(text string) error {
+	for _, c := range textChords(text) {
+		if err := u.Chord(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *UInput) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.file == nil {
+		return nil
+	}
+	ioctl(u.file, uiDevDestroy, 0)
+	err := u.file.Close()
+	u.file = nil
+	return err
+}
+
+// emit writes each event followed by a SYN_REPORT so that listeners see them
+// as separate key transitions.
+func (u *UInput) emit(events ...inputEvent) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.file == nil {
+		return fmt.Errorf("uinput device is closed")
+	}
+
+	var buf bytes.Buffer
+	for _, e := range events {
+		binary.Write(&buf, binary.NativeEndian, &e)
+		binary.Write(&buf, binary.NativeEndian, &inputEvent{Type: evSyn, Code: synReport})
+	}
+	if _, err := u.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing to %s: %v", uinputPath, err)
+	}
+	return nil
+}
+
+func keyInputEvent(code uint16, value int32) inputEvent {
+	return inputEvent{Type: evKey, Code: code, Value: value}
+}
+
+func lookupEvdevKey(key string) (uint16, error) {
+	k, ok := keys.Lookup(key)
+	if !ok {
+		return 0, fmt.Errorf("unknown key: %s", key)
+	}
+	if k.Evdev == 0 {
+		return 0, fmt.Errorf("no evdev mapping for key: %s", key)
+	}
+	return k.Evdev, nil
+}
+
+func ioctl(file *os.File, request, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
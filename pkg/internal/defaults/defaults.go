@@ -0,0 +1,21 @@
+// Package defaults applies the built-in defaults to config.yaml settings,
+// where zero or a negative value means the setting was left out.
+package defaults
+
+import "time"
+
+// Millis returns ms milliseconds, or fallback if ms is not positive.
+func Millis(ms int, fallback time.Duration) time.Duration {
+	if ms <= 0 {
+		return fallback
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// Int returns v, or fallback if v is not positive.
+func Int(v, fallback int) int {
+	if v <= 0 {
+		return fallback
+	}
+	return v
+}
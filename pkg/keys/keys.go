@@ -0,0 +1,78 @@
+package keys
+
+import (
+	"fmt"
+	"sort"
+
+	vk "mediacontrol/pkg/winVirtualKeyCodes"
+)
+
+// Key is a platform-neutral key such as "media.play_pause", "volume.up",
+// "key.a" or "mod.ctrl", with its code on every supported platform.
+type Key struct {
+	Name   string
+	VKName string
+	VK     uint16
+	Evdev  uint16
+	Keysym uint32
+}
+
+var (
+	byName   = make(map[string]*Key)
+	byVKName = make(map[string]*Key)
+)
+
+func init() {
+	for i := range table {
+		k := &table[i]
+		code, ok := vk.Lookup(k.VKName)
+		if !ok {
+			panic(fmt.Sprintf("keys: %s maps to unknown virtual key %s", k.Name, k.VKName))
+		}
+		k.VK = code
+		byName[k.Name] = k
+		byVKName[k.VKName] = k
+	}
+}
+
+// Lookup resolves a logical name, or a Windows VK_* name (or alias) kept for
+// compatibility with older servers. VK_* names without a logical equivalent
+// resolve to a Windows-only Key with no Evdev or Keysym.
+func Lookup(name string) (Key, bool) {
+	if k, ok := byName[name]; ok {
+		return *k, true
+	}
+
+	vkName := vk.Canonical(name)
+	if k, ok := byVKName[vkName]; ok {
+		return *k, true
+	}
+	if code, ok := vk.Lookup(vkName); ok {
+		return Key{Name: vkName, VKName: vkName, VK: code}, true
+	}
+	return Key{}, false
+}
+
+// Canonical returns the logical name for name, so that "VK_VOLUME_UP" and
+// "volume.up" compare equal. Unknown names are returned unchanged.
+func Canonical(name string) string {
+	if k, ok := Lookup(name); ok {
+		return k.Name
+	}
+	return name
+}
+
+func Validate(name string) error {
+	if _, ok := Lookup(name); !ok {
+		return fmt.Errorf("unknown key: %s", name)
+	}
+	return nil
+}
+
+// All returns every logical key, sorted by name.
+func All() []Key {
+	all := make([]Key, len(table))
+	copy(all, table)
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
@@ -0,0 +1,162 @@
+package keys
+
+// table lists every logical key with its Windows virtual key, Linux evdev
+// code (linux/input-event-codes.h) and X11 keysym (X11/keysymdef.h and
+// XF86keysym.h). A zero Keysym means X11 has no keysym for it.
+var table = []Key{
+	{Name: "media.play_pause", VKName: "VK_MEDIA_PLAY_PAUSE", Evdev: 164, Keysym: 0x1008FF14},
+	{Name: "media.next", VKName: "VK_MEDIA_NEXT_TRACK", Evdev: 163, Keysym: 0x1008FF17},
+	{Name: "media.previous", VKName: "VK_MEDIA_PREV_TRACK", Evdev: 165, Keysym: 0x1008FF16},
+	{Name: "media.stop", VKName: "VK_MEDIA_STOP", Evdev: 166, Keysym: 0x1008FF15},
+	{Name: "media.play", VKName: "VK_PLAY", Evdev: 207, Keysym: 0x1008FF14},
+	{Name: "media.select", VKName: "VK_LAUNCH_MEDIA_SELECT", Evdev: 226, Keysym: 0x1008FF32},
+	{Name: "volume.up", VKName: "VK_VOLUME_UP", Evdev: 115, Keysym: 0x1008FF13},
+	{Name: "volume.down", VKName: "VK_VOLUME_DOWN", Evdev: 114, Keysym: 0x1008FF11},
+	{Name: "volume.mute", VKName: "VK_VOLUME_MUTE", Evdev: 113, Keysym: 0x1008FF12},
+	{Name: "browser.back", VKName: "VK_BROWSER_BACK", Evdev: 158, Keysym: 0x1008FF26},
+	{Name: "browser.forward", VKName: "VK_BROWSER_FORWARD", Evdev: 159, Keysym: 0x1008FF27},
+	{Name: "browser.refresh", VKName: "VK_BROWSER_REFRESH", Evdev: 173, Keysym: 0x1008FF29},
+	{Name: "browser.stop", VKName: "VK_BROWSER_STOP", Evdev: 128, Keysym: 0x1008FF28},
+	{Name: "browser.search", VKName: "VK_BROWSER_SEARCH", Evdev: 217, Keysym: 0x1008FF1B},
+	{Name: "browser.favorites", VKName: "VK_BROWSER_FAVORITES", Evdev: 364, Keysym: 0x1008FF30},
+	{Name: "browser.home", VKName: "VK_BROWSER_HOME", Evdev: 172, Keysym: 0x1008FF18},
+	{Name: "launch.mail", VKName: "VK_LAUNCH_MAIL", Evdev: 155, Keysym: 0x1008FF19},
+	{Name: "launch.app1", VKName: "VK_LAUNCH_APP1", Evdev: 157, Keysym: 0x1008FF33},
+	{Name: "launch.app2", VKName: "VK_LAUNCH_APP2", Evdev: 140, Keysym: 0x1008FF1D},
+	{Name: "system.sleep", VKName: "VK_SLEEP", Evdev: 142, Keysym: 0x1008FF2F},
+	{Name: "mod.shift", VKName: "VK_SHIFT", Evdev: 42, Keysym: 0xFFE1},
+	{Name: "mod.ctrl", VKName: "VK_CONTROL", Evdev: 29, Keysym: 0xFFE3},
+	{Name: "mod.alt", VKName: "VK_MENU", Evdev: 56, Keysym: 0xFFE9},
+	{Name: "mod.meta", VKName: "VK_LWIN", Evdev: 125, Keysym: 0xFFEB},
+	{Name: "mod.lshift", VKName: "VK_LSHIFT", Evdev: 42, Keysym: 0xFFE1},
+	{Name: "mod.rshift", VKName: "VK_RSHIFT", Evdev: 54, Keysym: 0xFFE2},
+	{Name: "mod.lctrl", VKName: "VK_LCONTROL", Evdev: 29, Keysym: 0xFFE3},
+	{Name: "mod.rctrl", VKName: "VK_RCONTROL", Evdev: 97, Keysym: 0xFFE4},
+	{Name: "mod.lalt", VKName: "VK_LMENU", Evdev: 56, Keysym: 0xFFE9},
+	{Name: "mod.ralt", VKName: "VK_RMENU", Evdev: 100, Keysym: 0xFFEA},
+	{Name: "mod.rmeta", VKName: "VK_RWIN", Evdev: 126, Keysym: 0xFFEC},
+	{Name: "key.a", VKName: "VK_A", Evdev: 30, Keysym: 0x61},
+	{Name: "key.b", VKName: "VK_B", Evdev: 48, Keysym: 0x62},
+	{Name: "key.c", VKName: "VK_C", Evdev: 46, Keysym: 0x63},
+	{Name: "key.d", VKName: "VK_D", Evdev: 32, Keysym: 0x64},
+	{Name: "key.e", VKName: "VK_E", Evdev: 18, Keysym: 0x65},
+	{Name: "key.f", VKName: "VK_F", Evdev: 33, Keysym: 0x66},
+	{Name: "key.g", VKName: "VK_G", Evdev: 34, Keysym: 0x67},
+	{Name: "key.h", VKName: "VK_H", Evdev: 35, Keysym: 0x68},
+	{Name: "key.i", VKName: "VK_I", Evdev: 23, Keysym: 0x69},
+	{Name: "key.j", VKName: "VK_J", Evdev: 36, Keysym: 0x6A},
+	{Name: "key.k", VKName: "VK_K", Evdev: 37, Keysym: 0x6B},
+	{Name: "key.l", VKName: "VK_L", Evdev: 38, Keysym: 0x6C},
+	{Name: "key.m", VKName: "VK_M", Evdev: 50, Keysym: 0x6D},
+	{Name: "key.n", VKName: "VK_N", Evdev: 49, Keysym: 0x6E},
+	{Name: "key.o", VKName: "VK_O", Evdev: 24, Keysym: 0x6F},
+	{Name: "key.p", VKName: "VK_P", Evdev: 25, Keysym: 0x70},
+	{Name: "key.q", VKName: "VK_Q", Evdev: 16, Keysym: 0x71},
+	{Name: "key.r", VKName: "VK_R", Evdev: 19, Keysym: 0x72},
+	{Name: "key.s", VKName: "VK_S", Evdev: 31, Keysym: 0x73},
+	{Name: "key.t", VKName: "VK_T", Evdev: 20, Keysym: 0x74},
+	{Name: "key.u", VKName: "VK_U", Evdev: 22, Keysym: 0x75},
+	{Name: "key.v", VKName: "VK_V", Evdev: 47, Keysym: 0x76},
+	{Name: "key.w", VKName: "VK_W", Evdev: 17, Keysym: 0x77},
+	{Name: "key.x", VKName: "VK_X", Evdev: 45, Keysym: 0x78},
+	{Name: "key.y", VKName: "VK_Y", Evdev: 21, Keysym: 0x79},
+	{Name: "key.z", VKName: "VK_Z", Evdev: 44, Keysym: 0x7A},
+	{Name: "key.0", VKName: "VK_0", Evdev: 11, Keysym: 0x30},
+	{Name: "key.1", VKName: "VK_1", Evdev: 2, Keysym: 0x31},
+	{Name: "key.2", VKName: "VK_2", Evdev: 3, Keysym: 0x32},
+	{Name: "key.3", VKName: "VK_3", Evdev: 4, Keysym: 0x33},
+	{Name: "key.4", VKName: "VK_4", Evdev: 5, Keysym: 0x34},
+	{Name: "key.5", VKName: "VK_5", Evdev: 6, Keysym: 0x35},
+	{Name: "key.6", VKName: "VK_6", Evdev: 7, Keysym: 0x36},
+	{Name: "key.7", VKName: "VK_7", Evdev: 8, Keysym: 0x37},
+	{Name: "key.8", VKName: "VK_8", Evdev: 9, Keysym: 0x38},
+	{Name: "key.9", VKName: "VK_9", Evdev: 10, Keysym: 0x39},
+	{Name: "key.f1", VKName: "VK_F1", Evdev: 59, Keysym: 0xFFBE},
+	{Name: "key.f2", VKName: "VK_F2", Evdev: 60, Keysym: 0xFFBF},
+	{Name: "key.f3", VKName: "VK_F3", Evdev: 61, Keysym: 0xFFC0},
+	{Name: "key.f4", VKName: "VK_F4", Evdev: 62, Keysym: 0xFFC1},
+	{Name: "key.f5", VKName: "VK_F5", Evdev: 63, Keysym: 0xFFC2},
+	{Name: "key.f6", VKName: "VK_F6", Evdev: 64, Keysym: 0xFFC3},
+	{Name: "key.f7", VKName: "VK_F7", Evdev: 65, Keysym: 0xFFC4},
+	{Name: "key.f8", VKName: "VK_F8", Evdev: 66, Keysym: 0xFFC5},
+	{Name: "key.f9", VKName: "VK_F9", Evdev: 67, Keysym: 0xFFC6},
+	{Name: "key.f10", VKName: "VK_F10", Evdev: 68, Keysym: 0xFFC7},
+	{Name: "key.f11", VKName: "VK_F11", Evdev: 87, Keysym: 0xFFC8},
+	{Name: "key.f12", VKName: "VK_F12", Evdev: 88, Keysym: 0xFFC9},
+	{Name: "key.f13", VKName: "VK_F13", Evdev: 183, Keysym: 0xFFCA},
+	{Name: "key.f14", VKName: "VK_F14", Evdev: 184, Keysym: 0xFFCB},
+	{Name: "key.f15", VKName: "VK_F15", Evdev: 185, Keysym: 0xFFCC},
+	{Name: "key.f16", VKName: "VK_F16", Evdev: 186, Keysym: 0xFFCD},
+	{Name: "key.f17", VKName: "VK_F17", Evdev: 187, Keysym: 0xFFCE},
+	{Name: "key.f18", VKName: "VK_F18", Evdev: 188, Keysym: 0xFFCF},
+	{Name: "key.f19", VKName: "VK_F19", Evdev: 189, Keysym: 0xFFD0},
+	{Name: "key.f20", VKName: "VK_F20", Evdev: 190, Keysym: 0xFFD1},
+	{Name: "key.f21", VKName: "VK_F21", Evdev: 191, Keysym: 0xFFD2},
+	{Name: "key.f22", VKName: "VK_F22", Evdev: 192, Keysym: 0xFFD3},
+	{Name: "key.f23", VKName: "VK_F23", Evdev: 193, Keysym: 0xFFD4},
+	{Name: "key.f24", VKName: "VK_F24", Evdev: 194, Keysym: 0xFFD5},
+	{Name: "key.enter", VKName: "VK_RETURN", Evdev: 28, Keysym: 0xFF0D},
+	{Name: "key.escape", VKName: "VK_ESCAPE", Evdev: 1, Keysym: 0xFF1B},
+	{Name: "key.tab", VKName: "VK_TAB", Evdev: 15, Keysym: 0xFF09},
+	{Name: "key.backspace", VKName: "VK_BACK", Evdev: 14, Keysym: 0xFF08},
+	{Name: "key.space", VKName: "VK_SPACE", Evdev: 57, Keysym: 0x20},
+	{Name: "key.delete", VKName: "VK_DELETE", Evdev: 111, Keysym: 0xFFFF},
+	{Name: "key.insert", VKName: "VK_INSERT", Evdev: 110, Keysym: 0xFF63},
+	{Name: "key.home", VKName: "VK_HOME", Evdev: 102, Keysym: 0xFF50},
+	{Name: "key.end", VKName: "VK_END", Evdev: 107, Keysym: 0xFF57},
+	{Name: "key.page_up", VKName: "VK_PRIOR", Evdev: 104, Keysym: 0xFF55},
+	{Name: "key.page_down", VKName: "VK_NEXT", Evdev: 109, Keysym: 0xFF56},
+	{Name: "key.left", VKName: "VK_LEFT", Evdev: 105, Keysym: 0xFF51},
+	{Name: "key.up", VKName: "VK_UP", Evdev: 103, Keysym: 0xFF52},
+	{Name: "key.right", VKName: "VK_RIGHT", Evdev: 106, Keysym: 0xFF53},
+	{Name: "key.down", VKName: "VK_DOWN", Evdev: 108, Keysym: 0xFF54},
+	{Name: "key.caps_lock", VKName: "VK_CAPITAL", Evdev: 58, Keysym: 0xFFE5},
+	{Name: "key.num_lock", VKName: "VK_NUMLOCK", Evdev: 69, Keysym: 0xFF7F},
+	{Name: "key.scroll_lock", VKName: "VK_SCROLL", Evdev: 70, Keysym: 0xFF14},
+	{Name: "key.pause", VKName: "VK_PAUSE", Evdev: 119, Keysym: 0xFF13},
+	{Name: "key.print_screen", VKName: "VK_SNAPSHOT", Evdev: 99, Keysym: 0xFF61},
+	{Name: "key.menu", VKName: "VK_APPS", Evdev: 127, Keysym: 0xFF67},
+	{Name: "key.minus", VKName: "VK_OEM_MINUS", Evdev: 12, Keysym: 0x2D},
+	{Name: "key.equal", VKName: "VK_OEM_PLUS", Evdev: 13, Keysym: 0x3D},
+	{Name: "key.left_bracket", VKName: "VK_OEM_4", Evdev: 26, Keysym: 0x5B},
+	{Name: "key.right_bracket", VKName: "VK_OEM_6", Evdev: 27, Keysym: 0x5D},
+	{Name: "key.backslash", VKName: "VK_OEM_5", Evdev: 43, Keysym: 0x5C},
+	{Name: "key.semicolon", VKName: "VK_OEM_1", Evdev: 39, Keysym: 0x3B},
+	{Name: "key.apostrophe", VKName: "VK_OEM_7", Evdev: 40, Keysym: 0x27},
+	{Name: "key.grave", VKName: "VK_OEM_3", Evdev: 41, Keysym: 0x60},
+	{Name: "key.comma", VKName: "VK_OEM_COMMA", Evdev: 51, Keysym: 0x2C},
+	{Name: "key.period", VKName: "VK_OEM_PERIOD", Evdev: 52, Keysym: 0x2E},
+	{Name: "key.slash", VKName: "VK_OEM_2", Evdev: 53, Keysym: 0x2F},
+	{Name: "key.oem_102", VKName: "VK_OEM_102", Evdev: 86, Keysym: 0x3C},
+	{Name: "key.cancel", VKName: "VK_CANCEL", Evdev: 223, Keysym: 0xFF69},
+	{Name: "key.clear", VKName: "VK_CLEAR", Evdev: 355, Keysym: 0xFF0B},
+	{Name: "key.select", VKName: "VK_SELECT", Evdev: 353, Keysym: 0xFF60},
+	{Name: "key.print", VKName: "VK_PRINT", Evdev: 210, Keysym: 0xFF61},
+	{Name: "key.help", VKName: "VK_HELP", Evdev: 138, Keysym: 0xFF6A},
+	{Name: "key.kana", VKName: "VK_KANA", Evdev: 122, Keysym: 0xFF31},
+	{Name: "key.hanja", VKName: "VK_HANJA", Evdev: 123, Keysym: 0xFF34},
+	{Name: "key.convert", VKName: "VK_CONVERT", Evdev: 92, Keysym: 0xFF23},
+	{Name: "key.nonconvert", VKName: "VK_NONCONVERT", Evdev: 94, Keysym: 0xFF22},
+	{Name: "key.zoom", VKName: "VK_ZOOM", Evdev: 372},
+	{Name: "key.numpad_0", VKName: "VK_NUMPAD0", Evdev: 82, Keysym: 0xFFB0},
+	{Name: "key.numpad_1", VKName: "VK_NUMPAD1", Evdev: 79, Keysym: 0xFFB1},
+	{Name: "key.numpad_2", VKName: "VK_NUMPAD2", Evdev: 80, Keysym: 0xFFB2},
+	{Name: "key.numpad_3", VKName: "VK_NUMPAD3", Evdev: 81, Keysym: 0xFFB3},
+	{Name: "key.numpad_4", VKName: "VK_NUMPAD4", Evdev: 75, Keysym: 0xFFB4},
+	{Name: "key.numpad_5", VKName: "VK_NUMPAD5", Evdev: 76, Keysym: 0xFFB5},
+	{Name: "key.numpad_6", VKName: "VK_NUMPAD6", Evdev: 77, Keysym: 0xFFB6},
+	{Name: "key.numpad_7", VKName: "VK_NUMPAD7", Evdev: 71, Keysym: 0xFFB7},
+	{Name: "key.numpad_8", VKName: "VK_NUMPAD8", Evdev: 72, Keysym: 0xFFB8},
+	{Name: "key.numpad_9", VKName: "VK_NUMPAD9", Evdev: 73, Keysym: 0xFFB9},
+	{Name: "key.numpad_multiply", VKName: "VK_MULTIPLY", Evdev: 55, Keysym: 0xFFAA},
+	{Name: "key.numpad_add", VKName: "VK_ADD", Evdev: 78, Keysym: 0xFFAB},
+	{Name: "key.numpad_separator", VKName: "VK_SEPARATOR", Evdev: 121, Keysym: 0xFFAC},
+	{Name: "key.numpad_subtract", VKName: "VK_SUBTRACT", Evdev: 74, Keysym: 0xFFAD},
+	{Name: "key.numpad_decimal", VKName: "VK_DECIMAL", Evdev: 83, Keysym: 0xFFAE},
+	{Name: "key.numpad_divide", VKName: "VK_DIVIDE", Evdev: 98, Keysym: 0xFFAF},
+	{Name: "mouse.left", VKName: "VK_LBUTTON", Evdev: 272},
+	{Name: "mouse.right", VKName: "VK_RBUTTON", Evdev: 273},
+	{Name: "mouse.middle", VKName: "VK_MBUTTON", Evdev: 274},
+	{Name: "mouse.back", VKName: "VK_XBUTTON1", Evdev: 275},
+	{Name: "mouse.forward", VKName: "VK_XBUTTON2", Evdev: 276},
+}
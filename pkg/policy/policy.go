@@ -0,0 +1,162 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+
+	"mediacontrol/pkg/keys"
+	"mediacontrol/pkg/websocket"
+)
+
+type Config struct {
+	AllowedKeys     []string `yaml:"allowed_keys"`
+	AllowedCommands []string `yaml:"allowed_commands"`
+	AllowedMacros   []string `yaml:"allowed_macros"`
+}
+
+// DefaultKeys are the keys the server may press when allowed_keys is not set.
+var DefaultKeys = []string{
+	"media.play_pause",
+	"media.next",
+	"media.previous",
+	"media.stop",
+	"volume.up",
+	"volume.down",
+	"volume.mute",
+}
+
+// DefaultCommands are the message types accepted when allowed_commands is
+// not set. Typing text and running macros have to be opted into.
+var DefaultCommands = []string{"keyCode"}
+
+// Policy decides which remote commands may run. Keys and commands are denied
+// unless listed; macros are allowed by default because they are defined
+// locally in config.yaml, unless allowed_macros narrows them down.
+type Policy struct {
+	keys     map[string]bool
+	commands map[string]bool
+	macros   map[string]bool
+}
+
+type DeniedError struct {
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return "denied by policy: " + e.Reason
+}
+
+// New builds the policy for cfg. Every name in allowed_keys must be a known
+// key, so that a typo is reported instead of silently allowing nothing.
+func New(cfg Config) (*Policy, error) {
+	allowedKeys := cfg.AllowedKeys
+	if len(allowedKeys) == 0 {
+		allowedKeys = DefaultKeys
+	}
+	for _, key := range allowedKeys {
+		if err := keys.Validate(key); err != nil {
+			return nil, fmt.Errorf("allowed_keys: %v", err)
+		}
+	}
+	commands := cfg.AllowedCommands
+	if len(commands) == 0 {
+		commands = DefaultCommands
+	}
+
+	p := &Policy{
+		keys:     keySet(allowedKeys),
+		commands: toSet(commands),
+	}
+	if len(cfg.AllowedMacros) > 0 {
+		p.macros = toSet(cfg.AllowedMacros)
+	}
+	return p, nil
+}
+
+// Check implements websocket.Policy.
+func (p *Policy) Check(msg any) error {
+	switch m := msg.(type) {
+	case websocket.KeyCodeMessage:
+		if err := p.CheckCommand(m.Type); err != nil {
+			return err
+		}
+		for _, stroke := range m.Strokes() {
+			if err := p.CheckKeys(stroke.Modifiers...); err != nil {
+				return err
+			}
+			if err := p.CheckKeys(stroke.KeyCode); err != nil {
+				return err
+			}
+		}
+		return nil
+	case websocket.TypeTextMessage:
+		return p.CheckCommand(m.Type)
+	case websocket.MacroMessage:
+		if err := p.CheckCommand(m.Type); err != nil {
+			return err
+		}
+		return p.CheckMacro(m.Name)
+	default:
+		return &DeniedError{Reason: fmt.Sprintf("unsupported message %T", msg)}
+	}
+}
+
+func (p *Policy) CheckCommand(msgType string) error {
+	if !p.commands[msgType] {
+		return &DeniedError{Reason: fmt.Sprintf("command %s is not allowed", msgType)}
+	}
+	return nil
+}
+
+func (p *Policy) CheckKeys(names ...string) error {
+	for _, key := range names {
+		if !p.keys[keys.Canonical(key)] {
+			return &DeniedError{Reason: fmt.Sprintf("key %s is not allowed", key)}
+		}
+	}
+	return nil
+}
+
+func (p *Policy) CheckMacro(name string) error {
+	if p.macros != nil && !p.macros[name] {
+		return &DeniedError{Reason: fmt.Sprintf("macro %s is not allowed", name)}
+	}
+	return nil
+}
+
+// AllowedKeys returns the logical names of the allowed keys, sorted.
+func (p *Policy) AllowedKeys() []string {
+	return sortedKeys(p.keys)
+}
+
+// AllowedCommands returns the allowed message types, sorted.
+func (p *Policy) AllowedCommands() []string {
+	return sortedKeys(p.commands)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// keySet stores logical names so that a VK_* name in the config matches the
+// logical name for the same key.
+func keySet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, key := range names {
+		set[keys.Canonical(key)] = true
+	}
+	return set
+}
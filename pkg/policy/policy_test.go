@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mediacontrol/pkg/websocket"
+)
+
+func mustNew(t *testing.T, cfg Config) *Policy {
+	t.Helper()
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New(%+v): %v", cfg, err)
+	}
+	return p
+}
+
+func keyCode(key string, modifiers ...string) websocket.KeyCodeMessage {
+	return websocket.KeyCodeMessage{Type: "keyCode", KeyCode: key, Modifiers: modifiers}
+}
+
+func checkDenied(t *testing.T, err error, what string) {
+	t.Helper()
+	var denied *DeniedError
+	if !errors.As(err, &denied) {
+		t.Errorf("%s: err = %v, want DeniedError", what, err)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	p := mustNew(t, Config{})
+
+	if got := p.AllowedCommands(); !reflect.DeepEqual(got, []string{"keyCode"}) {
+		t.Errorf("AllowedCommands() = %v, want [keyCode]", got)
+	}
+	if got := p.AllowedKeys(); len(got) != len(DefaultKeys) {
+		t.Errorf("AllowedKeys() = %v, want the %d default keys", got, len(DefaultKeys))
+	}
+	for _, key := range DefaultKeys {
+		if err := p.Check(keyCode(key)); err != nil {
+			t.Errorf("default key %s: %v", key, err)
+		}
+	}
+	// Windows names match the logical key.
+	if err := p.Check(keyCode("VK_VOLUME_UP")); err != nil {
+		t.Errorf("VK_VOLUME_UP: %v", err)
+	}
+
+	checkDenied(t, p.Check(keyCode("key.a")), "unlisted key")
+	checkDenied(t, p.Check(websocket.TypeTextMessage{Type: "typeText", Text: "hi"}), "typeText by default")
+	checkDenied(t, p.Check(websocket.MacroMessage{Type: "macro", Name: "skip_track"}), "macro by default")
+	checkDenied(t, p.Check("something else"), "unsupported message")
+}
+
+func TestAllowedKeys(t *testing.T) {
+	p := mustNew(t, Config{AllowedKeys: []string{"key.m", "mod.ctrl", "VK_SHIFT"}})
+
+	if err := p.Check(keyCode("key.m", "mod.ctrl", "mod.shift")); err != nil {
+		t.Errorf("chord of listed keys: %v", err)
+	}
+	checkDenied(t, p.Check(keyCode("key.m", "mod.ctrl", "mod.alt")), "chord with an unlisted modifier")
+	checkDenied(t, p.Check(keyCode("key.n", "mod.ctrl")), "chord with an unlisted key")
+	checkDenied(t, p.Check(keyCode("media.next")), "default key not in allowed_keys")
+
+	seq := websocket.KeyCodeMessage{Type: "keyCode", Sequence: []websocket.KeyStroke{
+		{KeyCode: "key.m"},
+		{KeyCode: "key.n"},
+	}}
+	checkDenied(t, p.Check(seq), "sequence with an unlisted key")
+}
+
+func TestAllowedKeysUnknown(t *testing.T) {
+	_, err := New(Config{AllowedKeys: []string{"volume.up", "volume_upp"}})
+	if err == nil || !strings.Contains(err.Error(), "volume_upp") {
+		t.Errorf("New with a misspelled key: err = %v, want error naming it", err)
+	}
+}
+
+func TestAllowedCommands(t *testing.T) {
+	p := mustNew(t, Config{AllowedCommands: []string{"typeText", "macro"}})
+
+	if err := p.Check(websocket.TypeTextMessage{Type: "typeText", Text: "hi"}); err != nil {
+		t.Errorf("typeText: %v", err)
+	}
+	if err := p.Check(websocket.MacroMessage{Type: "macro", Name: "anything"}); err != nil {
+		t.Errorf("macro without allowed_macros: %v", err)
+	}
+	checkDenied(t, p.Check(keyCode("media.next")), "keyCode not in allowed_commands")
+}
+
+func TestAllowedMacros(t *testing.T) {
+	p := mustNew(t, Config{AllowedCommands: []string{"macro"}, AllowedMacros: []string{"skip_track"}})
+
+	if err := p.Check(websocket.MacroMessage{Type: "macro", Name: "skip_track"}); err != nil {
+		t.Errorf("listed macro: %v", err)
+	}
+	checkDenied(t, p.Check(websocket.MacroMessage{Type: "macro", Name: "launch_shell"}), "unlisted macro")
+}
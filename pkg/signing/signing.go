@@ -0,0 +1,279 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"mediacontrol/pkg/internal/defaults"
+)
+
+// Config holds the signed-command settings from config.yaml.
+type Config struct {
+	// Enabled makes the client run only commands signed by one of PhoneKeys,
+	// so that the relay server cannot inject commands of its own.
+	Enabled bool `yaml:"enabled"`
+	// KeyFile holds this desktop's private key. It is created on first use.
+	KeyFile string `yaml:"key_file"`
+	// PhoneKeys are the base64 Ed25519 public keys of the enrolled phones.
+	PhoneKeys []string `yaml:"phone_keys"`
+	// MaxAgeMs is how far a command's signing time may be from the local
+	// clock, in either direction, before it is rejected as stale.
+	MaxAgeMs int `yaml:"max_age_ms"`
+}
+
+const (
+	// SignatureField, NonceField and SignedAtField are the JSON fields a
+	// signed command carries next to its payload. SignedAtField is the
+	// phone's clock in Unix milliseconds when it signed the command.
+	SignatureField = "sig"
+	NonceField     = "nonce"
+	SignedAtField  = "signedAt"
+
+	minNonceLength = 16
+	maxNonces      = 4096
+	defaultMaxAge  = 30 * time.Second
+)
+
+// relayFields are set or rewritten by the relay server in transit (the
+// server's command ID and timestamp, the sender and the addressed user), so
+// they are not covered by the signature.
+var relayFields = map[string]bool{"id": true, "ts": true, "sender": true, "userId": true}
+
+// LoadOrCreateKey reads the desktop's private key from path, generating and
+// saving a new one if the file does not exist yet.
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid key in %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(key.Seed())
+	if err := os.WriteFile(path, []byte(encoded+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodePublicKey returns the base64 form used in config.yaml and the hello
+// message.
+func EncodePublicKey(key ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// Fingerprint returns a short hex digest of key for comparing keys by eye
+// when enrolling a phone.
+func Fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	digest := hex.EncodeToString(sum[:8])
+	return strings.Join([]string{digest[0:4], digest[4:8], digest[8:12], digest[12:16]}, ":")
+}
+
+// Canonical returns the bytes a command's signature covers: every field of
+// the message except sig and the fields the relay sets (id, ts, sender and
+// userId), re-encoded as compact JSON with object keys sorted, so that
+// whitespace and key order added in transit do not matter. The phone therefore
+// signs the type, the payload, deviceId when it addresses one desktop, nonce
+// and signedAt.
+func Canonical(raw []byte) ([]byte, error) {
+	fields, err := decodeObject(raw)
+	if err != nil {
+		return nil, err
+	}
+	return canonical(fields)
+}
+
+func canonical(fields map[string]any) ([]byte, error) {
+	signed := make(map[string]any, len(fields))
+	for k, v := range fields {
+		if k != SignatureField && !relayFields[k] {
+			signed[k] = v
+		}
+	}
+
+	// Match what other JSON encoders produce; encoding/json would escape
+	// <, > and & by default.
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(signed); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Sign adds a nonce, the signing time and a signature by key to the JSON
+// object msg. It is what an enrolled phone does before sending a command.
+func Sign(key ed25519.PrivateKey, msg []byte) ([]byte, error) {
+	return sign(key, msg, time.Now())
+}
+
+func sign(key ed25519.PrivateKey, msg []byte, now time.Time) ([]byte, error) {
+	fields, err := decodeObject(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	fields[NonceField] = base64.RawURLEncoding.EncodeToString(nonce)
+	fields[SignedAtField] = now.UnixMilli()
+
+	payload, err := canonical(fields)
+	if err != nil {
+		return nil, err
+	}
+	fields[SignatureField] = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return json.Marshal(fields)
+}
+
+func decodeObject(raw []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	// Keep numbers as written; float64 would round large integers.
+	decoder.UseNumber()
+
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("error decoding message: %v", err)
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("message is not a JSON object")
+	}
+	return fields, nil
+}
+
+// Verifier accepts only commands signed by an enrolled phone key, recently,
+// each with a nonce that has not been used before. Nonces are remembered for
+// as long as their command is fresh, so a command can never be replayed: it
+// is either a known nonce or stale. Commands signed before the verifier was
+// created are rejected too, so that a restart does not forget nonces that
+// are still fresh.
+type Verifier struct {
+	keys   []ed25519.PublicKey
+	maxAge time.Duration
+	since  time.Time
+
+	nonces    map[string]time.Time // nonce to signing time
+	maxNonces int
+	mu        sync.Mutex
+}
+
+func NewVerifier(cfg Config) (*Verifier, error) {
+	v := &Verifier{
+		maxAge: defaults.Millis(cfg.MaxAgeMs, defaultMaxAge),
+		// Signing times only have millisecond precision.
+		since:     time.Now().Truncate(time.Millisecond),
+		nonces:    make(map[string]time.Time),
+		maxNonces: maxNonces,
+	}
+	for _, encoded := range cfg.PhoneKeys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid phone key %q", encoded)
+		}
+		v.keys = append(v.keys, ed25519.PublicKey(key))
+	}
+	return v, nil
+}
+
+// Verify checks the signature, signing time and nonce of a raw inbound
+// command.
+func (v *Verifier) Verify(raw []byte) error {
+	return v.verify(raw, time.Now())
+}
+
+func (v *Verifier) verify(raw []byte, now time.Time) error {
+	fields, err := decodeObject(raw)
+	if err != nil {
+		return err
+	}
+
+	encodedSig, _ := fields[SignatureField].(string)
+	if encodedSig == "" {
+		return fmt.Errorf("command is not signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(encodedSig)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature")
+	}
+	nonce, _ := fields[NonceField].(string)
+	if len(nonce) < minNonceLength {
+		return fmt.Errorf("command has no nonce or it is too short")
+	}
+	number, _ := fields[SignedAtField].(json.Number)
+	ms, err := number.Int64()
+	if err != nil {
+		return fmt.Errorf("command has no signing time")
+	}
+	signedAt := time.UnixMilli(ms)
+
+	payload, err := canonical(fields)
+	if err != nil {
+		return err
+	}
+
+	signed := false
+	for _, key := range v.keys {
+		if ed25519.Verify(key, payload, sig) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return fmt.Errorf("signature does not match any enrolled phone key")
+	}
+
+	if age := now.Sub(signedAt); age > v.maxAge || age < -v.maxAge {
+		return fmt.Errorf("stale command: signed %v ago", age.Round(time.Millisecond))
+	}
+	if signedAt.Before(v.since) {
+		return fmt.Errorf("command was signed before the desktop started accepting commands")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.nonces[nonce]; ok {
+		return fmt.Errorf("nonce %s was already used", nonce)
+	}
+	if len(v.nonces) >= v.maxNonces {
+		for n, t := range v.nonces {
+			if now.Sub(t) > v.maxAge {
+				delete(v.nonces, n)
+			}
+		}
+		// Forgetting a nonce that is still fresh would allow a replay.
+		if len(v.nonces) >= v.maxNonces {
+			return fmt.Errorf("too many signed commands within %v", v.maxAge)
+		}
+	}
+	v.nonces[nonce] = signedAt
+	return nil
+}
@@ -0,0 +1,192 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newPhone(t *testing.T) (ed25519.PrivateKey, *Verifier) {
+	t.Helper()
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(Config{PhoneKeys: []string{EncodePublicKey(public)}, MaxAgeMs: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, v
+}
+
+func mustSign(t *testing.T, key ed25519.PrivateKey, msg string, at time.Time) []byte {
+	t.Helper()
+	signed, err := sign(key, []byte(msg), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// setField changes one field of a signed command, as a relay would.
+func setField(t *testing.T, raw []byte, field string, value any) []byte {
+	t.Helper()
+	fields, err := decodeObject(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields[field] = value
+	out, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+const command = `{"type":"keyCode","keyCode":"media.play_pause","deviceId":"desk"}`
+
+func TestVerify(t *testing.T) {
+	key, v := newPhone(t)
+	now := time.Now()
+
+	signed := mustSign(t, key, command, now)
+	if err := v.verify(signed, now); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := v.verify(signed, now); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("replayed nonce: err = %v", err)
+	}
+}
+
+func TestVerifyRelayFields(t *testing.T) {
+	key, v := newPhone(t)
+	now := time.Now()
+
+	// The relay adds its own ID, timestamp, sender and user without
+	// breaking the signature.
+	signed := mustSign(t, key, command, now)
+	for field, value := range map[string]any{"id": "abc", "ts": now.UnixMilli(), "sender": "phone", "userId": "user"} {
+		signed = setField(t, signed, field, value)
+	}
+	if err := v.verify(signed, now); err != nil {
+		t.Errorf("verify with relay fields: %v", err)
+	}
+
+	// Everything else is signed.
+	for field, value := range map[string]any{"keyCode": "media.next", "deviceId": "other", "type": "macro", "signedAt": now.UnixMilli() + 1, "extra": true} {
+		tampered := setField(t, mustSign(t, key, command, now), field, value)
+		if err := v.verify(tampered, now); err == nil {
+			t.Errorf("verify with %s changed: want error", field)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	key, v := newPhone(t)
+	now := time.Now()
+	otherKey, _ := newPhone(t)
+
+	unsigned := []byte(command)
+	noTime := setField(t, mustSign(t, key, command, now), SignedAtField, nil)
+	tests := []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{"unsigned", unsigned, "not signed"},
+		{"other key", mustSign(t, otherKey, command, now), "does not match"},
+		{"no signing time", noTime, "no signing time"},
+		{"stale", mustSign(t, key, command, now.Add(-1500*time.Millisecond)), "stale"},
+		{"from the future", mustSign(t, key, command, now.Add(1500*time.Millisecond)), "stale"},
+		{"before start", mustSign(t, key, command, v.since.Add(-time.Millisecond)), "before the desktop started"},
+	}
+	for _, tt := range tests {
+		if err := v.verify(tt.raw, now); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyReplayAfterRestart(t *testing.T) {
+	public, key, _ := ed25519.GenerateKey(rand.Reader)
+	cfg := Config{PhoneKeys: []string{EncodePublicKey(public)}, MaxAgeMs: 1000}
+
+	before, _ := NewVerifier(cfg)
+	signed := mustSign(t, key, command, time.Now())
+	if err := before.Verify(signed); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new verifier has no nonces, but does not accept the old command.
+	time.Sleep(2 * time.Millisecond)
+	after, _ := NewVerifier(cfg)
+	if err := after.Verify(signed); err == nil {
+		t.Error("replay to a new verifier: want error")
+	}
+}
+
+func TestVerifyNonceWindow(t *testing.T) {
+	key, v := newPhone(t)
+	v.maxNonces = 16
+	now := time.Now()
+
+	first := mustSign(t, key, command, now)
+	if err := v.verify(first, now); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < v.maxNonces; i++ {
+		if err := v.verify(mustSign(t, key, command, now), now); err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+	}
+	// The window is full of fresh nonces; none may be forgotten.
+	if err := v.verify(mustSign(t, key, command, now), now); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Errorf("command over the window: err = %v", err)
+	}
+	if err := v.verify(first, now); err == nil {
+		t.Error("replay of the first command: want error")
+	}
+
+	// Once they are stale the nonces make room for new commands.
+	later := now.Add(1500 * time.Millisecond)
+	if err := v.verify(mustSign(t, key, command, later), later); err != nil {
+		t.Errorf("command after the window expired: %v", err)
+	}
+	if err := v.verify(first, later); err == nil {
+		t.Error("replay of an expired command: want error")
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	a, err := Canonical([]byte(`{"b": 1, "a": "<&>", "sig": "x", "ts": 5, "n": 12345678901234567890}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"a":"<&>","b":1,"n":12345678901234567890}`
+	if string(a) != want {
+		t.Errorf("Canonical = %s, want %s", a, want)
+	}
+	if _, err := Canonical([]byte(`[1]`)); err == nil {
+		t.Error("Canonical of an array: want error")
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "device_key")
+	key, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, again) {
+		t.Error("key changed after reloading")
+	}
+}
@@ -0,0 +1,175 @@
+// Package proxytest provides HTTP CONNECT and SOCKS5 proxies for exercising
+// proxied connections in tests. Both require the user name "user" and the
+// password "pass", and send every tunnel to one target address whatever host
+// the client asked for, so that clients can use a host name that would not
+// bypass the proxy.
+package proxytest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Proxy is a running proxy. Call Close when done.
+type Proxy struct {
+	// URL is the proxy's URL, without credentials.
+	URL string
+
+	ln     net.Listener
+	target string
+
+	mu    sync.Mutex
+	hosts []string
+}
+
+// NewConnect starts an HTTP proxy that tunnels CONNECT requests to target.
+func NewConnect(target string) *Proxy {
+	p := &Proxy{target: target}
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	p.serve("http", func(conn net.Conn) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		if req.Header.Get("Proxy-Authorization") != want {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		p.record(req.Host)
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		p.tunnel(conn)
+	})
+	return p
+}
+
+// NewSOCKS5 starts a SOCKS5 proxy that tunnels CONNECT requests to target.
+func NewSOCKS5(target string) *Proxy {
+	p := &Proxy{target: target}
+	p.serve("socks5", func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		read := func(n int) []byte {
+			b := make([]byte, n)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil
+			}
+			return b
+		}
+
+		// Greeting: offer username/password authentication only.
+		head := read(2)
+		if head == nil || head[0] != 5 || read(int(head[1])) == nil {
+			return
+		}
+		conn.Write([]byte{5, 2})
+
+		// RFC 1929 username/password.
+		ver := read(2)
+		if ver == nil {
+			return
+		}
+		user := read(int(ver[1]))
+		plen := read(1)
+		if user == nil || plen == nil {
+			return
+		}
+		pass := read(int(plen[0]))
+		if string(user) != "user" || string(pass) != "pass" {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+
+		// CONNECT request.
+		req := read(4)
+		if req == nil || req[1] != 1 {
+			return
+		}
+		var host string
+		switch req[3] {
+		case 1:
+			host = net.IP(read(4)).String()
+		case 3:
+			n := read(1)
+			if n == nil {
+				return
+			}
+			host = string(read(int(n[0])))
+		case 4:
+			host = net.IP(read(16)).String()
+		}
+		port := read(2)
+		if port == nil {
+			return
+		}
+		p.record(net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		p.tunnel(conn)
+	})
+	return p
+}
+
+// Close stops accepting connections.
+func (p *Proxy) Close() {
+	p.ln.Close()
+}
+
+// WithCredentials returns the proxy's URL with userinfo, e.g. "user:pass".
+func (p *Proxy) WithCredentials(userinfo string) string {
+	scheme, rest, _ := strings.Cut(p.URL, "://")
+	return scheme + "://" + userinfo + "@" + rest
+}
+
+// Hosts returns the host:port of every tunnel clients asked for, in order.
+func (p *Proxy) Hosts() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.hosts...)
+}
+
+func (p *Proxy) record(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.hosts = append(p.hosts, host)
+}
+
+func (p *Proxy) tunnel(client net.Conn) {
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	go io.Copy(upstream, client)
+	io.Copy(client, upstream)
+}
+
+func (p *Proxy) serve(scheme string, handle func(net.Conn)) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("proxytest: failed to listen: %v", err))
+	}
+	p.ln = ln
+	p.URL = scheme + "://" + ln.Addr().String()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TLSConfig holds the TLS settings from config.yaml.
+type TLSConfig struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots, for
+	// self-hosted servers with a private CA.
+	CAFile string `yaml:"ca_file"`
+	// Pins are base64 SHA-256 hashes of a certificate's SubjectPublicKeyInfo,
+	// optionally prefixed with "sha256/". When set, the server's chain must
+	// contain at least one pinned key.
+	Pins []string `yaml:"pins"`
+}
+
+// ClientConfig builds the tls.Config for connections to the server. It
+// returns nil when nothing is configured, meaning the Go defaults apply.
+func (t TLSConfig) ClientConfig() (*tls.Config, error) {
+	if t.CAFile == "" && len(t.Pins) == 0 {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if t.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", t.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if len(t.Pins) > 0 {
+		pins := make([][]byte, 0, len(t.Pins))
+		for _, pin := range t.Pins {
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+			if err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("invalid certificate pin %q", pin)
+			}
+			pins = append(pins, hash)
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state, pins)
+		}
+	}
+
+	return config, nil
+}
+
+// verifyPins runs after the normal chain verification, so it only narrows
+// down which valid certificates are accepted.
+func verifyPins(state tls.ConnectionState, pins [][]byte) error {
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(hash[:], pin) {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("server certificate does not match any configured pin")
+}
+
+// PinFor returns the pin string for a certificate, for use in config.yaml.
+func PinFor(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(hash[:])
+}
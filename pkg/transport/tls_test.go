@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCAFile saves the test server's certificate as a PEM bundle.
+func writeCAFile(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func get(t *testing.T, tlsConfig TLSConfig, url string) error {
+	t.Helper()
+	client, err := HTTPClient(tlsConfig, ProxyConfig{}, 0)
+	if err != nil {
+		t.Fatalf("HTTPClient: %v", err)
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func newTLSServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestClientConfigDefault(t *testing.T) {
+	config, err := TLSConfig{}.ClientConfig()
+	if err != nil || config != nil {
+		t.Errorf("ClientConfig() = %v, %v; want nil, nil", config, err)
+	}
+}
+
+func TestCAFile(t *testing.T) {
+	srv := newTLSServer(t)
+
+	if err := get(t, TLSConfig{}, srv.URL); err == nil {
+		t.Error("request without the CA file: want certificate error")
+	}
+	if err := get(t, TLSConfig{CAFile: writeCAFile(t, srv)}, srv.URL); err != nil {
+		t.Errorf("request with the CA file: %v", err)
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0644)
+	for _, path := range []string{empty, filepath.Join(t.TempDir(), "missing.pem")} {
+		if _, err := (TLSConfig{CAFile: path}).ClientConfig(); err == nil {
+			t.Errorf("ClientConfig with CA file %s: want error", path)
+		}
+	}
+}
+
+func TestPins(t *testing.T) {
+	srv := newTLSServer(t)
+	caFile := writeCAFile(t, srv)
+
+	pin := PinFor(srv.Certificate())
+	if err := get(t, TLSConfig{CAFile: caFile, Pins: []string{pin}}, srv.URL); err != nil {
+		t.Errorf("request with a matching pin: %v", err)
+	}
+	// The "sha256/" prefix is optional.
+	if err := get(t, TLSConfig{CAFile: caFile, Pins: []string{strings.TrimPrefix(pin, "sha256/")}}, srv.URL); err != nil {
+		t.Errorf("request with an unprefixed pin: %v", err)
+	}
+
+	other := sha256.Sum256([]byte("some other key"))
+	otherPin := "sha256/" + base64.StdEncoding.EncodeToString(other[:])
+	err := get(t, TLSConfig{CAFile: caFile, Pins: []string{otherPin}}, srv.URL)
+	if err == nil || !strings.Contains(err.Error(), "does not match any configured pin") {
+		t.Errorf("request with a mismatching pin: err = %v, want pin error", err)
+	}
+	// Any pinned key in the chain is enough.
+	if err := get(t, TLSConfig{CAFile: caFile, Pins: []string{otherPin, pin}}, srv.URL); err != nil {
+		t.Errorf("request with one matching pin of two: %v", err)
+	}
+
+	for _, bad := range []string{"sha256/not base64!", "sha256/" + base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := (TLSConfig{Pins: []string{bad}}).ClientConfig(); err == nil {
+			t.Errorf("ClientConfig with pin %q: want error", bad)
+		}
+	}
+}
@@ -1,9 +1,15 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
+	"sort"
 	"sync"
 	"time"
 
@@ -11,175 +17,584 @@ import (
 )
 
 type Client struct {
-	conn       *websocket.Conn
-	done       chan struct{}
-	send       chan []byte
-	webappURL  string
-	token      string
-	userID     string
-	onKeyPress func(string)
-	onStatus   func(bool)
-	closed     bool
-	mu         sync.Mutex
-}
+	conn      *websocket.Conn
+	done      chan struct{}
+	send      chan []byte
+	commands  chan *Request
+	webappURL string
+	token     string
+	userID    string
+	config    Config
+	hello     HelloMessage
+	router    *Router
+	replay    *replayGuard
+	limiter   *rateLimiter
+	onStatus  func(Status)
+	policy    Policy
+	verifier  Verifier
+	closed    bool
+	mu        sync.Mutex
+
+	// refreshToken gets a new session token after the handshake was
+	// refused; refreshed is set while the refreshed token has not been
+	// accepted yet, so that it is only tried once. Guarded by mu, as is
+	// token.
+	refreshToken func() (string, error)
+	refreshed    bool
+
+	// Device identity, guarded by mu.
+	deviceID   string
+	deviceName string
 
-type Message struct {
-	Type    string          `json:"type"`
-	Command string          `json:"command,omitempty"`
-	Data    json.RawMessage `json:"data,omitempty"`
+	// Lifecycle state, guarded by mu. ctx is cancelled when the client is
+	// closed, which aborts a dial in progress; pumps counts the running
+	// read and write pumps and the command worker so that Run can wait for
+	// them.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running bool
+	stopErr error
+	pumps   sync.WaitGroup
+
+	// Reconnection state, guarded by mu.
+	stopped     bool
+	attempt     int
+	connectedAt time.Time
+	retryTimer  *time.Timer
+	lastPong    time.Time
+	metrics     metricsState
 }
 
-type KeyCodeMessage struct {
-	Type    string `json:"type"`
-	KeyCode string `json:"keyCode"`
-	UserID  string `json:"userId"`
+// Status describes the connection for the status handler.
+type Status struct {
+	Connected bool
+	// Attempt counts reconnection attempts since the last stable connection.
+	Attempt int
+	// NextRetry is when the next reconnection attempt is due, or zero if none
+	// is scheduled.
+	NextRetry time.Time
+	// Err is why the connection dropped or the last attempt failed.
+	Err error
+	// LockedUntil is set while commands are ignored because a sender
+	// exceeded its rate limits.
+	LockedUntil time.Time
 }
 
 func NewClient(webappURL, token, userID string) *Client {
-	return &Client{
+	c := &Client{
 		done:      make(chan struct{}),
 		send:      make(chan []byte, 256),
+		commands:  make(chan *Request, commandQueueSize),
 		webappURL: webappURL,
 		token:     token,
 		userID:    userID,
+		router:    NewRouter(),
+		replay:    newReplayGuard(ReplayConfig{}),
+		limiter:   newRateLimiter(RateLimitConfig{}),
+		closed:    true,
+	}
+	// Messages for other devices and users are dropped first, then rate
+	// limiting drops a flood, unknown and malformed messages included,
+	// before it produces a log line or a result per message.
+	c.router.UseEnvelope(c.checkTarget, AuthMiddleware(userID), c.limitRate)
+	c.router.Use(LoggingMiddleware, c.checkSignature, c.checkReplay, c.checkPolicy)
+	return c
+}
+
+// Router returns the router inbound commands are dispatched through, for
+// registering further message types and middleware.
+func (c *Client) Router() *Router {
+	return c.router
+}
+
+func (c *Client) SetConfig(config Config) {
+	c.config = config
+	c.replay = newReplayGuard(config.Replay)
+	c.limiter = newRateLimiter(config.RateLimit)
+}
+
+// SetHello sets what the client advertises in the hello message sent on each
+// connection. Type, ProtocolVersion and MessageTypes are filled in by the
+// client, the latter from the router and getMetrics, and so is the device
+// identity.
+func (c *Client) SetHello(hello HelloMessage) {
+	c.hello = hello
+}
+
+// SetTokenRefresher sets how to get a new session token when the server
+// refuses the current one with 401 or 403. refresh returns an error wrapping
+// ErrUnauthorized when the session cannot be refreshed; the client then stops
+// with ErrUnauthorized instead of retrying a dead token, as it does without a
+// refresher. Other errors, e.g. from the network, are retried.
+func (c *Client) SetTokenRefresher(refresh func() (string, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refreshToken = refresh
+}
+
+// SetDevice sets the identity sent when connecting, which lets the server
+// tell the user's desktops apart and address commands to one of them.
+func (c *Client) SetDevice(id, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deviceID = id
+	c.deviceName = name
+}
+
+// RenameDevice changes the device name, telling the server right away if
+// connected.
+func (c *Client) RenameDevice(name string) error {
+	c.mu.Lock()
+	c.deviceName = name
+	connected, id := !c.closed, c.deviceID
+	c.mu.Unlock()
+
+	if !connected {
+		return nil
 	}
+	return c.sendJSON(DeviceInfoMessage{Type: "deviceInfo", DeviceID: id, DeviceName: name})
+}
+
+func (c *Client) SetKeyPressHandler(handler func(KeyCodeMessage) error) {
+	Handle(c.router, "keyCode", handler)
 }
 
-func (c *Client) SetKeyPressHandler(handler func(string)) {
-	c.onKeyPress = handler
+func (c *Client) SetTypeTextHandler(handler func(TypeTextMessage) error) {
+	Handle(c.router, "typeText", handler)
 }
 
-func (c *Client) SetConnectionStatusHandler(handler func(bool)) {
+func (c *Client) SetMacroHandler(handler func(MacroMessage) error) {
+	Handle(c.router, "macro", handler)
+}
+
+func (c *Client) SetPolicy(policy Policy) {
+	c.policy = policy
+}
+
+// SetVerifier makes the client run only commands the verifier accepts.
+func (c *Client) SetVerifier(verifier Verifier) {
+	c.verifier = verifier
+}
+
+func (c *Client) SetConnectionStatusHandler(handler func(Status)) {
 	c.onStatus = handler
 }
 
+// Run connects and keeps the client connected, reconnecting as configured,
+// until ctx is cancelled or Close is called. It then closes the connection
+// and waits for the in-flight command, if any, before returning. The Set*
+// methods must not be called once Run has started.
+//
+// Run returns nil after Close, ctx.Err() after cancellation, and an error
+// wrapping ErrIncompatibleProtocol or ErrUnauthorized if the server refused
+// the client or its session token.
+func (c *Client) Run(ctx context.Context) error {
+	c.mu.Lock()
+	if c.running {
+		c.mu.Unlock()
+		return errors.New("client is already running")
+	}
+	c.running = true
+	c.startLocked(ctx)
+	clientCtx := c.ctx
+	c.pumps.Add(1)
+	go c.runCommands(clientCtx)
+	c.mu.Unlock()
+
+	if err := c.connect(); err != nil {
+		log.Printf("Error connecting to WebSocket: %v", err)
+	}
+
+	<-clientCtx.Done()
+	c.Close()
+	c.pumps.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = false
+	if c.stopErr != nil {
+		return c.stopErr
+	}
+	return ctx.Err()
+}
+
+// Connect dials the server right away, cancelling any pending reconnection
+// attempt, to force an immediate reconnection while Run is active. It returns
+// an error once the client has stopped or Run has returned, so that no
+// connection outlives the context passed to Run.
 func (c *Client) Connect() error {
-	if c.conn != nil {
-		c.Close()
+	c.mu.Lock()
+	if !c.running || c.stopped {
+		c.mu.Unlock()
+		return errors.New("client is not running")
 	}
+	c.cancelRetryLocked()
+	c.mu.Unlock()
 
-	c.done = make(chan struct{})
-	c.send = make(chan []byte, 256)
-	c.closed = false
+	return c.connect()
+}
+
+func (c *Client) startLocked(parent context.Context) {
+	c.ctx, c.cancel = context.WithCancel(parent)
+	c.stopped = false
+	c.stopErr = nil
+	c.cancelRetryLocked()
+}
 
-	u, err := url.Parse(c.webappURL)
+// stop ends the client's lifecycle: no further reconnection attempts are
+// made and a dial in progress is aborted. cause, if not nil, is returned by
+// Run.
+func (c *Client) stop(cause error) (wasOpen bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	c.cancelRetryLocked()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if cause != nil && c.stopErr == nil {
+		c.stopErr = cause
+	}
+	wasOpen = !c.closed
+	if wasOpen && cause != nil {
+		c.recordDisconnectLocked(cause)
+	}
+	c.closeConnLocked()
+	return wasOpen
+}
+
+func (c *Client) connect() error {
+	c.closeConn()
+
+	c.mu.Lock()
+	ctx := c.ctx
+	deviceID, deviceName := c.deviceID, c.deviceName
+	token := c.token
+	c.mu.Unlock()
+
+	wsURL, err := websocketURL(c.webappURL)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := c.config.TLS.ClientConfig()
+	if err != nil {
+		return err
+	}
+	proxy, err := c.config.Proxy.ProxyFunc()
 	if err != nil {
 		return err
 	}
-	u.Scheme = "ws"
-	u.Path = "/_ws/"
 
 	header := make(map[string][]string)
-	header["Authorization"] = []string{"Bearer " + c.token}
+	header["Authorization"] = []string{"Bearer " + token}
+	if deviceID != "" {
+		header["X-Device-Id"] = []string{deviceID}
+		header["X-Device-Name"] = []string{url.QueryEscape(deviceName)}
+	}
 
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 45 * time.Second,
+		TLSClientConfig:  tlsConfig,
+		Proxy:            proxy,
 	}
 
-	conn, _, err := dialer.Dial(u.String(), header)
+	conn, resp, err := dialer.DialContext(ctx, wsURL.String(), header)
 	if err != nil {
-		if c.onStatus != nil {
-			c.onStatus(false)
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return c.handleAuthFailure(resp.StatusCode)
 		}
+		c.scheduleReconnect(err)
 		return err
 	}
 
-	c.conn = conn
-	if c.onStatus != nil {
-		c.onStatus(true)
-	}
+	c.keepAlive(conn)
+	conn.SetReadLimit(maxMessageSize)
 
-	go c.readPump()
-	go c.writePump()
+	// The hello goes out before the write pump starts, so that nothing
+	// queued while offline can be sent ahead of it.
+	hello := c.hello
+	hello.Type = "hello"
+	hello.ProtocolVersion = ProtocolVersion
+	// getMetrics is answered by the client itself, without a handler.
+	hello.MessageTypes = append(c.router.Types(), "getMetrics")
+	sort.Strings(hello.MessageTypes)
+	hello.DeviceID = deviceID
+	hello.DeviceName = deviceName
+	conn.SetWriteDeadline(time.Now().Add(c.config.Heartbeat.writeTimeout()))
+	if err := conn.WriteJSON(hello); err != nil {
+		conn.Close()
+		err = fmt.Errorf("error sending hello: %v", err)
+		c.scheduleReconnect(err)
+		return err
+	}
 
-	pingMsg := Message{
-		Type:    "command",
-		Command: "ping",
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		conn.Close()
+		return fmt.Errorf("client closed")
 	}
+	// A concurrent Connect may have won the race to dial; keep only the
+	// newest connection.
+	c.closeConnLocked()
+	c.conn = conn
+	c.done = make(chan struct{})
+	c.closed = false
+	c.connectedAt = time.Now()
+	c.refreshed = false
+	c.recordConnectLocked()
+	done := c.done
+	attempt := c.attempt
+	c.pumps.Add(2)
+	c.mu.Unlock()
+
+	c.setStatus(Status{Connected: true, Attempt: attempt})
+
+	go c.readPump(conn, done)
+	go c.writePump(conn, done)
 
-	msgBytes, err := json.Marshal(pingMsg)
+	return nil
+}
+
+// websocketURL derives the /_ws/ endpoint from the web app URL, using wss
+// for https so that the bearer token never travels in plaintext to a
+// production server.
+func websocketURL(webappURL string) (*url.URL, error) {
+	u, err := url.Parse(webappURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	c.send <- msgBytes
+	switch u.Scheme {
+	case "https", "wss":
+		u.Scheme = "wss"
+	case "http", "ws":
+		u.Scheme = "ws"
+		if host := u.Hostname(); host != "localhost" && !isLoopback(host) {
+			log.Printf("Warning: connecting to %s without TLS, the session token is sent in plaintext", u.Host)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported webapp URL scheme %q", u.Scheme)
+	}
+	u.Path = "/_ws/"
+	return u, nil
+}
 
-	return nil
+func isLoopback(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
 
-func (c *Client) readPump() {
+func (c *Client) readPump(conn *websocket.Conn, done chan struct{}) {
+	defer c.pumps.Done()
+
+	var readErr error
 	defer func() {
 		c.mu.Lock()
-		defer c.mu.Unlock()
-
-		if !c.closed {
-			c.closed = true
-			if c.conn != nil {
-				c.conn.Close()
-			}
-			close(c.done)
-			if c.onStatus != nil {
-				c.onStatus(false)
+		// Only tear down the connection this pump was started for; a newer
+		// one may already have replaced it.
+		dropped := c.conn == conn && !c.closed
+		if dropped {
+			if readErr == nil {
+				readErr = fmt.Errorf("connection closed")
 			}
+			c.closed = true
+			conn.Close()
+			close(done)
+			c.recordDisconnectLocked(readErr)
+		}
+		c.mu.Unlock()
+
+		if dropped {
+			c.scheduleReconnect(readErr)
 		}
 	}()
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket read error: %v", err)
 			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				liveness := c.config.Heartbeat.livenessTimeout()
+				log.Printf("WebSocket connection dead: nothing received for %v", liveness)
+				err = fmt.Errorf("%w within %v", errLivenessTimeout, liveness)
+			}
+			readErr = err
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(c.config.Heartbeat.livenessTimeout()))
 
-		log.Printf("Received WebSocket message: %s", string(message))
+		c.dispatch(message)
+	}
+}
 
-		var msg KeyCodeMessage
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("Error parsing message: %v", err)
-			log.Printf("Message: %s", string(message))
-			continue
-		}
+func (c *Client) sendResult(req *Request, status string, cause error) {
+	result := ResultMessage{
+		Type:    "result",
+		ID:      req.ID,
+		Command: req.Type,
+		Status:  status,
+	}
+	if cause != nil {
+		result.Error = cause.Error()
+	}
+	if err := c.sendJSON(result); err != nil {
+		log.Printf("Error sending result for %s message: %v", req.Type, err)
+	}
+}
 
-		if msg.Type == "keyCode" {
-			if msg.UserID != c.userID {
-				log.Printf("Received message from different user ID: %s (expected: %s)", msg.UserID, c.userID)
-				continue
-			}
+func (c *Client) sendJSON(v any) error {
+	msgBytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
 
-			if c.onKeyPress != nil {
-				c.onKeyPress(msg.KeyCode)
-			}
-		}
+	select {
+	case c.send <- msgBytes:
+		return nil
+	default:
+		return fmt.Errorf("send buffer full")
 	}
 }
 
-func (c *Client) writePump() {
+func (c *Client) writePump(conn *websocket.Conn, done chan struct{}) {
+	defer c.pumps.Done()
+
+	ticker := time.NewTicker(c.config.Heartbeat.pingInterval())
 	defer func() {
-		c.conn.Close()
+		ticker.Stop()
+		conn.Close()
 	}()
 
+	writeTimeout := c.config.Heartbeat.writeTimeout()
+	// Ping right away so that the latency is known without waiting a full
+	// interval.
+	if err := conn.WriteControl(websocket.PingMessage, pingPayload(), time.Now().Add(writeTimeout)); err != nil {
+		log.Printf("WebSocket ping error: %v", err)
+		return
+	}
 	for {
 		select {
 		case message := <-c.send:
-			err := c.conn.WriteMessage(websocket.TextMessage, message)
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err := conn.WriteMessage(websocket.TextMessage, message)
 			if err != nil {
 				log.Printf("WebSocket write error: %v", err)
 				return
 			}
-		case <-c.done:
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, pingPayload(), time.Now().Add(writeTimeout)); err != nil {
+				log.Printf("WebSocket ping error: %v", err)
+				return
+			}
+		case <-done:
 			return
 		}
 	}
 }
 
+// Close closes the connection and stops any further reconnection attempts.
+// It does not wait for the pumps to exit; Run does.
 func (c *Client) Close() {
+	if c.stop(nil) {
+		c.setStatus(Status{})
+	}
+}
+
+// handleAuthFailure refreshes the session token and reconnects once after
+// the server refused the handshake. If the session cannot be refreshed, the
+// client stops: retrying with the same token would only be refused again. A
+// refresh that failed for any other reason is retried with the usual backoff.
+func (c *Client) handleAuthFailure(statusCode int) error {
+	err := fmt.Errorf("%w (HTTP %d)", ErrUnauthorized, statusCode)
+
+	c.mu.Lock()
+	refresh, refreshed := c.refreshToken, c.refreshed
+	c.mu.Unlock()
+
+	if refresh != nil && !refreshed {
+		log.Printf("WebSocket handshake refused with HTTP %d, refreshing the session token", statusCode)
+		token, refreshErr := refresh()
+		if refreshErr == nil {
+			c.mu.Lock()
+			c.token = token
+			c.refreshed = true
+			c.mu.Unlock()
+			return c.connect()
+		}
+		if !errors.Is(refreshErr, ErrUnauthorized) {
+			// The web app could not be reached or failed; the token
+			// may still be refreshed on a later attempt.
+			refreshErr = fmt.Errorf("error refreshing session token: %v", refreshErr)
+			c.scheduleReconnect(refreshErr)
+			return refreshErr
+		}
+		log.Printf("Error refreshing session token: %v", refreshErr)
+	}
+
+	log.Printf("WebSocket handshake refused: %v", err)
+	c.stop(err)
+	c.setStatus(Status{Err: err})
+	return err
+}
+
+// reject stops the client after the server refused its hello. Reconnecting
+// would only be refused again, so the client reports itself offline instead.
+func (c *Client) reject(rejected RejectedMessage) {
+	err := fmt.Errorf("%w: %s", ErrIncompatibleProtocol, rejected.Reason)
+	if rejected.MinProtocolVersion > 0 {
+		err = fmt.Errorf("%w (client speaks version %d, server needs %d)", err, ProtocolVersion, rejected.MinProtocolVersion)
+	}
+	log.Printf("Server rejected connection: %v", err)
+
+	c.stop(err)
+	c.setStatus(Status{Err: err})
+}
+
+// keepAlive arms the read deadline and pushes it back on every pong, so that
+// ReadMessage fails once the server has been silent for the liveness timeout.
+// maxMessageSize is the largest frame the server may send; commands are far
+// smaller, and a larger frame drops the connection.
+const maxMessageSize = 64 << 10
+
+func (c *Client) keepAlive(conn *websocket.Conn) {
+	liveness := c.config.Heartbeat.livenessTimeout()
+	conn.SetReadDeadline(time.Now().Add(liveness))
+	conn.SetPongHandler(func(payload string) error {
+		c.mu.Lock()
+		c.lastPong = time.Now()
+		c.mu.Unlock()
+		c.recordPong(payload)
+		return conn.SetReadDeadline(time.Now().Add(liveness))
+	})
+}
+
+// LastPong returns when the server last answered a ping.
+func (c *Client) LastPong() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lastPong
+}
+
+// closeConn closes the current connection without scheduling a reconnection.
+func (c *Client) closeConn() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.closeConnLocked()
+}
+
+func (c *Client) closeConnLocked() {
 	if !c.closed {
 		c.closed = true
+		c.recordDisconnectLocked(nil)
 		if c.conn != nil {
 			c.conn.Close()
 		}
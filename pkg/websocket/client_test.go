@@ -0,0 +1,567 @@
+package websocket
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mediacontrol/pkg/auth"
+	"mediacontrol/pkg/transport"
+	"mediacontrol/pkg/transport/proxytest"
+	"mediacontrol/pkg/websocket/wstest"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebsocketURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://app.example.com", "wss://app.example.com/_ws/"},
+		{"https://app.example.com:8443/some/path", "wss://app.example.com:8443/_ws/"},
+		{"wss://app.example.com", "wss://app.example.com/_ws/"},
+		{"http://localhost:3000", "ws://localhost:3000/_ws/"},
+		{"ws://127.0.0.1:3000", "ws://127.0.0.1:3000/_ws/"},
+	}
+	for _, tt := range tests {
+		u, err := websocketURL(tt.in)
+		if err != nil {
+			t.Errorf("websocketURL(%s): %v", tt.in, err)
+			continue
+		}
+		if u.String() != tt.want {
+			t.Errorf("websocketURL(%s) = %s, want %s", tt.in, u, tt.want)
+		}
+	}
+
+	if _, err := websocketURL("ftp://app.example.com"); err == nil {
+		t.Error("websocketURL(ftp://...): want error")
+	}
+}
+
+// newTLSEndpoint starts a wss /_ws/ endpoint that reports the hello of each
+// connection, and returns it with a CA file trusting it.
+func newTLSEndpoint(t *testing.T) (*httptest.Server, string, chan HelloMessage) {
+	t.Helper()
+	hellos := make(chan HelloMessage, 1)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_ws/" || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		up := websocket.Upgrader{}
+		conn, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var hello HelloMessage
+		if err := conn.ReadJSON(&hello); err == nil {
+			hellos <- hello
+		}
+		conn.ReadMessage()
+	}))
+	t.Cleanup(srv.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return srv, caFile, hellos
+}
+
+// dialOnce connects once without reconnecting and returns the first status.
+func dialOnce(t *testing.T, webappURL string, config Config) Status {
+	t.Helper()
+	statuses := make(chan Status, 10)
+	c := NewClient(webappURL, "token", "user")
+	config.Reconnect.Disabled = true
+	c.SetConfig(config)
+	c.SetConnectionStatusHandler(func(s Status) { statuses <- s })
+
+	go c.Run(t.Context())
+	select {
+	case s := <-statuses:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status reported")
+		return Status{}
+	}
+}
+
+func TestConnectTLS(t *testing.T) {
+	srv, caFile, hellos := newTLSEndpoint(t)
+	webappURL := srv.URL
+
+	if s := dialOnce(t, webappURL, Config{}); s.Connected {
+		t.Error("connected without trusting the server's CA")
+	}
+
+	if s := dialOnce(t, webappURL, Config{TLS: transport.TLSConfig{CAFile: caFile}}); !s.Connected {
+		t.Fatalf("not connected with the CA file: %v", s.Err)
+	}
+	select {
+	case hello := <-hellos:
+		if hello.Type != "hello" {
+			t.Errorf("first message = %+v, want hello", hello)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no hello received over wss")
+	}
+
+	pin := transport.PinFor(srv.Certificate())
+	if s := dialOnce(t, webappURL, Config{TLS: transport.TLSConfig{CAFile: caFile, Pins: []string{pin}}}); !s.Connected {
+		t.Errorf("not connected with a matching pin: %v", s.Err)
+	}
+
+	other := sha256.Sum256([]byte("some other key"))
+	otherPin := "sha256/" + base64.StdEncoding.EncodeToString(other[:])
+	s := dialOnce(t, webappURL, Config{TLS: transport.TLSConfig{CAFile: caFile, Pins: []string{otherPin}}})
+	if s.Connected || s.Err == nil || !strings.Contains(s.Err.Error(), "pin") {
+		t.Errorf("with a mismatching pin: status %+v, want a pin error", s)
+	}
+}
+
+func TestHelloFirst(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token", "user")
+	// Left over from an earlier connection, or queued while offline.
+	c.sendJSON(ResultMessage{Type: "result", ID: "stale", Command: "keyCode", Status: ResultOK})
+	go c.Run(t.Context())
+
+	if _, err := srv.WaitFrame("result", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	frames := srv.Frames()
+	if frames[0].Type != "hello" {
+		t.Errorf("first frame = %s, want hello", frames[0].Type)
+	}
+}
+
+func TestConnectOnlyWhileRunning(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token", "user")
+	if err := c.Connect(); err == nil {
+		t.Error("Connect before Run: want error")
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+	if err := srv.WaitAccepted(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Forced reconnections race with each other and with the pumps.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Connect(); err != nil {
+				t.Errorf("Connect while running: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := srv.WaitAccepted(5, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if c.Metrics().Connected {
+		t.Error("still connected after Run returned")
+	}
+	if err := c.Connect(); err == nil {
+		t.Error("Connect after Run: want error")
+	}
+}
+
+func TestConnectAfterRejection(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	srv.RejectHello("too old", ProtocolVersion+1)
+
+	c := NewClient(srv.URL, "token", "user")
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	if err := c.Run(ctx); !errors.Is(err, ErrIncompatibleProtocol) {
+		t.Fatalf("Run = %v, want ErrIncompatibleProtocol", err)
+	}
+
+	if err := c.Connect(); err == nil {
+		t.Error("Connect after the client stopped: want error")
+	}
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	if c.Metrics().Connected || srv.Accepted() != 1 {
+		t.Errorf("connected %v with %d connections accepted, want one connection, closed", c.Metrics().Connected, srv.Accepted())
+	}
+}
+
+func TestRefreshFailure(t *testing.T) {
+	srv := wstest.NewServer("new")
+	defer srv.Close()
+
+	// The web app is unreachable at first; the client keeps trying.
+	var mu sync.Mutex
+	calls := 0
+	c := NewClient(srv.URL, "old", "user")
+	c.SetConfig(Config{Reconnect: ReconnectConfig{InitialIntervalMs: 10, MaxIntervalMs: 10}})
+	c.SetTokenRefresher(func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls < 3 {
+			return "", errors.New("connection refused")
+		}
+		return "new", nil
+	})
+	go c.Run(t.Context())
+
+	if err := srv.WaitAccepted(1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 3 || srv.AuthFailures() != 3 {
+		t.Errorf("connected after %d refreshes and %d refused attempts, want 3 of each", calls, srv.AuthFailures())
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	srv := wstest.NewServer("new")
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "old", "user")
+	c.SetConfig(Config{Reconnect: ReconnectConfig{InitialIntervalMs: 10, MaxIntervalMs: 10}})
+	c.SetTokenRefresher(func() (string, error) {
+		return "", fmt.Errorf("%w: session expired", ErrUnauthorized)
+	})
+	if err := c.Run(t.Context()); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Run = %v, want ErrUnauthorized", err)
+	}
+	if n := srv.AuthFailures(); n != 1 {
+		t.Errorf("%d refused attempts, want 1", n)
+	}
+}
+
+// fastRetry reconnects almost at once, to keep the tests short.
+var fastRetry = Config{Reconnect: ReconnectConfig{InitialIntervalMs: 10, MaxIntervalMs: 10}}
+
+// waitResult waits for the result of the command with the given id.
+func waitResult(t *testing.T, srv *wstest.Server, id string) ResultMessage {
+	t.Helper()
+	for {
+		frame, err := srv.WaitFrame("result", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result ResultMessage
+		if err := frame.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.ID == id {
+			return result
+		}
+	}
+}
+
+func TestKeyCode(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+
+	pressed := make(chan KeyCodeMessage, 1)
+	c := NewClient(srv.URL, "token", "user")
+	c.SetKeyPressHandler(func(msg KeyCodeMessage) error {
+		pressed <- msg
+		return nil
+	})
+	go c.Run(t.Context())
+	if _, err := srv.WaitFrame("hello", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.SendKeyCode("user", "1", "media.play_pause"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-pressed:
+		if msg.KeyCode != "media.play_pause" || msg.ID != "1" {
+			t.Errorf("handler got %+v, want media.play_pause with id 1", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("keyCode did not reach the handler")
+	}
+
+	frame, err := srv.WaitFrame("ack", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ack AckMessage
+	frame.Decode(&ack)
+	if ack.ID != "1" || ack.Command != "keyCode" {
+		t.Errorf("ack = %+v, want keyCode 1", ack)
+	}
+	if result := waitResult(t, srv, "1"); result.Status != ResultOK || result.Command != "keyCode" {
+		t.Errorf("result = %+v, want keyCode ok", result)
+	}
+}
+
+func TestReconnectAfterDrop(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	c := startClient(t, srv, fastRetry)
+
+	srv.Drop()
+	if err := srv.WaitAccepted(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.WaitFrame("hello", 5*time.Second); err != nil {
+		t.Fatal("no hello after reconnecting:", err)
+	}
+
+	// Commands work again on the new connection.
+	if err := srv.SendKeyCode("user", "after drop", "media.next"); err != nil {
+		t.Fatal(err)
+	}
+	if result := waitResult(t, srv, "after drop"); result.Status != ResultOK {
+		t.Errorf("result after reconnecting = %+v, want ok", result)
+	}
+	if m := c.Metrics(); !m.Connected {
+		t.Error("not connected after reconnecting")
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	srv := wstest.NewServer("new")
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "old", "user")
+	c.SetConfig(fastRetry)
+	if err := c.Run(t.Context()); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("Run = %v, want ErrUnauthorized", err)
+	}
+	if n := srv.AuthFailures(); n != 1 || srv.Accepted() != 0 {
+		t.Errorf("%d refused and %d accepted attempts, want one refused", n, srv.Accepted())
+	}
+}
+
+func TestUnauthorizedRefresh(t *testing.T) {
+	srv := wstest.NewServer("new")
+	defer srv.Close()
+	srv.AllowRefresh("old")
+
+	// As the desktop app does, through the web app's refresh endpoint.
+	c := NewClient(srv.URL, "old", "user")
+	c.SetConfig(fastRetry)
+	c.SetTokenRefresher(func() (string, error) {
+		refreshed, err := auth.RefreshToken(&auth.TokenResponse{SessionToken: "old"}, srv.URL)
+		if errors.Is(err, auth.ErrSessionExpired) {
+			return "", fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+		if err != nil {
+			return "", err
+		}
+		return refreshed.SessionToken, nil
+	})
+	done := make(chan error, 1)
+	go func() { done <- c.Run(t.Context()) }()
+
+	if _, err := srv.WaitFrame("hello", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if srv.AuthFailures() != 1 || srv.Refreshes() != 1 {
+		t.Errorf("%d refused attempts and %d refreshes, want 1 of each", srv.AuthFailures(), srv.Refreshes())
+	}
+
+	// The refreshed token expires too, and cannot be refreshed again.
+	srv.SetToken("newer")
+	srv.Drop()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("Run = %v, want ErrUnauthorized", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop after the session expired")
+	}
+	if srv.Refreshes() != 1 {
+		t.Errorf("%d refreshes, want 1", srv.Refreshes())
+	}
+}
+
+func TestRejectHello(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	srv.RejectHello("protocol too old", ProtocolVersion+1)
+
+	statuses := make(chan Status, 10)
+	c := NewClient(srv.URL, "token", "user")
+	c.SetConfig(fastRetry)
+	c.SetConnectionStatusHandler(func(s Status) { statuses <- s })
+	err := c.Run(t.Context())
+	if !errors.Is(err, ErrIncompatibleProtocol) || !strings.Contains(err.Error(), "protocol too old") {
+		t.Fatalf("Run = %v, want ErrIncompatibleProtocol with the server's reason", err)
+	}
+
+	var last Status
+	for len(statuses) > 0 {
+		last = <-statuses
+	}
+	if last.Connected || !last.NextRetry.IsZero() || !errors.Is(last.Err, ErrIncompatibleProtocol) {
+		t.Errorf("last status = %+v, want disconnected without retry", last)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := srv.Accepted(); n != 1 {
+		t.Errorf("%d connections accepted, want no reconnection after the rejection", n)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	startClient(t, srv, fastRetry)
+
+	// An oversized frame drops the connection instead of being read.
+	srv.Send(map[string]any{"type": "keyCode", "userId": "user", "keyCode": strings.Repeat("a", maxMessageSize)})
+	if err := srv.WaitAccepted(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if frame, err := srv.WaitFrame("result", 100*time.Millisecond); err == nil {
+		t.Errorf("oversized frame got a result: %s", frame.Data)
+	}
+}
+
+func TestLivenessTimeout(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+
+	statuses := make(chan Status, 100)
+	c := NewClient(srv.URL, "token", "user")
+	c.SetConfig(Config{
+		Reconnect: ReconnectConfig{InitialIntervalMs: 10, MaxIntervalMs: 10},
+		Heartbeat: HeartbeatConfig{PingIntervalMs: 20, LivenessTimeoutMs: 100},
+	})
+	c.SetConnectionStatusHandler(func(s Status) { statuses <- s })
+	go c.Run(t.Context())
+
+	next := func() Status {
+		t.Helper()
+		select {
+		case s := <-statuses:
+			return s
+		case <-time.After(5 * time.Second):
+			t.Fatal("no status reported")
+			return Status{}
+		}
+	}
+	if s := next(); !s.Connected {
+		t.Fatalf("first status = %+v, want connected", s)
+	}
+
+	// The server goes silent, as after a Wi-Fi drop.
+	srv.IgnorePings(true)
+	s := next()
+	if s.Connected || !errors.Is(s.Err, errLivenessTimeout) || s.NextRetry.IsZero() {
+		t.Fatalf("status after the server went silent = %+v, want a liveness timeout and a retry", s)
+	}
+
+	srv.IgnorePings(false)
+	if err := srv.WaitAccepted(2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for s := next(); !s.Connected; s = next() {
+	}
+}
+
+func TestHelloMessageTypes(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token", "user")
+	c.SetKeyPressHandler(func(KeyCodeMessage) error { return nil })
+	c.SetMacroHandler(func(MacroMessage) error { return nil })
+	go c.Run(t.Context())
+
+	frame, err := srv.WaitFrame("hello", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hello HelloMessage
+	if err := frame.Decode(&hello); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"getMetrics", "keyCode", "macro"}
+	if !slices.Equal(hello.MessageTypes, want) {
+		t.Errorf("hello advertises %v, want %v", hello.MessageTypes, want)
+	}
+}
+
+func TestConnectThroughProxy(t *testing.T) {
+	t.Setenv("NO_PROXY", "")
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+
+	// The proxies tunnel to srv whatever the host; a name that is not
+	// localhost keeps the connection from bypassing the proxy.
+	target := strings.TrimPrefix(srv.URL, "http://")
+	_, port, _ := net.SplitHostPort(target)
+	webappURL := "http://example.com:" + port
+
+	proxies := map[string]*proxytest.Proxy{
+		"connect": proxytest.NewConnect(target),
+		"socks5":  proxytest.NewSOCKS5(target),
+	}
+	for name, p := range proxies {
+		defer p.Close()
+		t.Run(name, func(t *testing.T) {
+			accepted := srv.Accepted()
+			s := dialOnce(t, webappURL, Config{Proxy: transport.ProxyConfig{URL: p.WithCredentials("user:pass")}})
+			if !s.Connected {
+				t.Fatalf("not connected through the %s proxy: %v", name, s.Err)
+			}
+			if _, err := srv.WaitFrame("hello", 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if hosts := p.Hosts(); len(hosts) != 1 || hosts[0] != "example.com:"+port {
+				t.Errorf("proxy was asked for %v, want example.com:%s", hosts, port)
+			}
+
+			s = dialOnce(t, webappURL, Config{Proxy: transport.ProxyConfig{URL: p.WithCredentials("user:wrong")}})
+			if s.Connected {
+				t.Error("connected with wrong proxy credentials")
+			}
+			if n := srv.Accepted() - accepted; n != 1 {
+				t.Errorf("%d connections reached the server, want 1", n)
+			}
+		})
+	}
+}
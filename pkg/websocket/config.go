@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"time"
+
+	"mediacontrol/pkg/internal/defaults"
+	"mediacontrol/pkg/transport"
+)
+
+// Config holds the connection settings from config.yaml. Zero values fall
+// back to the defaults.
+type Config struct {
+	Reconnect ReconnectConfig       `yaml:"reconnect"`
+	Heartbeat HeartbeatConfig       `yaml:"heartbeat"`
+	TLS       transport.TLSConfig   `yaml:"tls"`
+	Proxy     transport.ProxyConfig `yaml:"proxy"`
+	Replay    ReplayConfig          `yaml:"replay"`
+	RateLimit RateLimitConfig       `yaml:"rate_limit"`
+}
+
+type ReconnectConfig struct {
+	Disabled          bool    `yaml:"disabled"`
+	InitialIntervalMs int     `yaml:"initial_interval_ms"`
+	MaxIntervalMs     int     `yaml:"max_interval_ms"`
+	Multiplier        float64 `yaml:"multiplier"`
+	// Jitter randomizes each interval by up to this fraction (0-1) so that
+	// many clients do not reconnect in lockstep after a server restart.
+	Jitter float64 `yaml:"jitter"`
+	// StableAfterMs is how long a connection must last for the backoff to
+	// start over from the initial interval when it drops.
+	StableAfterMs int `yaml:"stable_after_ms"`
+}
+
+// HeartbeatConfig controls the protocol-level pings that detect half-open
+// connections after a Wi-Fi drop or sleep.
+type HeartbeatConfig struct {
+	PingIntervalMs int `yaml:"ping_interval_ms"`
+	// LivenessTimeoutMs is how long the connection may stay silent (no
+	// message and no pong) before it is considered dead.
+	LivenessTimeoutMs int `yaml:"liveness_timeout_ms"`
+	WriteTimeoutMs    int `yaml:"write_timeout_ms"`
+}
+
+// ReplayConfig controls the rejection of duplicate and stale commands.
+type ReplayConfig struct {
+	// WindowSize is how many recent command IDs are remembered.
+	WindowSize int `yaml:"window_size"`
+	// MaxAgeMs is how far a command's server timestamp may be from the
+	// server's current time, in either direction, before the command is
+	// rejected as stale. The server's time is estimated from the welcome, so
+	// the local clock does not need to be right; without a time in the
+	// welcome, timestamps are not checked.
+	MaxAgeMs int `yaml:"max_age_ms"`
+	// Require rejects commands that carry no ID or timestamp. Leave it off
+	// for servers that do not send them yet.
+	Require bool `yaml:"require"`
+}
+
+// RateLimitConfig limits how fast each sender may send commands.
+type RateLimitConfig struct {
+	Disabled bool `yaml:"disabled"`
+	// Limits overrides the built-in limits per message type. The class
+	// "keyCode:volume" applies to keyCode commands that only press volume
+	// keys, which get a larger burst by default, and "unknown" to every
+	// message type without a handler.
+	Limits map[string]RateLimit `yaml:"limits"`
+	// LockoutThreshold is how many commands may be rejected within LockoutMs
+	// before the sender is ignored for LockoutMs.
+	LockoutThreshold int `yaml:"lockout_threshold"`
+	LockoutMs        int `yaml:"lockout_ms"`
+}
+
+// RateLimit is a token bucket: Burst commands at once, refilled at PerSecond.
+type RateLimit struct {
+	PerSecond float64 `yaml:"per_second"`
+	Burst     int     `yaml:"burst"`
+}
+
+const (
+	defaultInitialInterval = time.Second
+	defaultMaxInterval     = time.Minute
+	defaultMultiplier      = 2.0
+	defaultJitter          = 0.2
+	defaultStableAfter     = 30 * time.Second
+
+	defaultPingInterval    = 25 * time.Second
+	defaultLivenessTimeout = 60 * time.Second
+	defaultWriteTimeout    = 10 * time.Second
+
+	defaultReplayWindow = 1024
+	defaultReplayMaxAge = 30 * time.Second
+
+	defaultLockoutThreshold = 20
+	defaultLockout          = 10 * time.Second
+)
+
+func (h HeartbeatConfig) pingInterval() time.Duration {
+	return defaults.Millis(h.PingIntervalMs, defaultPingInterval)
+}
+
+// livenessTimeout is never shorter than two ping intervals, otherwise a
+// healthy but idle connection would be dropped between pings.
+func (h HeartbeatConfig) livenessTimeout() time.Duration {
+	return max(defaults.Millis(h.LivenessTimeoutMs, defaultLivenessTimeout), 2*h.pingInterval())
+}
+
+func (h HeartbeatConfig) writeTimeout() time.Duration {
+	return defaults.Millis(h.WriteTimeoutMs, defaultWriteTimeout)
+}
@@ -0,0 +1,201 @@
+package websocket
+
+import (
+	"errors"
+
+	"mediacontrol/pkg/keys"
+)
+
+// Policy decides whether a decoded inbound message may be handed to its
+// handler. Denied messages are logged and reported back to the server as a
+// ResultMessage with status "denied".
+type Policy interface {
+	Check(msg any) error
+}
+
+// Verifier checks that a raw inbound command was signed by an enrolled
+// phone, so that the relay server cannot inject commands of its own.
+type Verifier interface {
+	Verify(raw []byte) error
+}
+
+// Result statuses reported back to the server.
+const (
+	ResultOK     = "ok"
+	ResultError  = "error"
+	ResultDenied = "denied"
+	// ResultUnsupported is reported for message types with no handler.
+	ResultUnsupported = "unsupported"
+	// ResultDuplicate is reported for a command whose ID was already seen;
+	// it was not run again.
+	ResultDuplicate = "duplicate"
+	// ResultRateLimited is reported for commands over the sender's rate
+	// limit; they were not run.
+	ResultRateLimited = "rate_limited"
+)
+
+// StatusError lets middleware report a command with a specific result
+// status instead of "denied".
+type StatusError struct {
+	Status string
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// AckMessage tells the server a command with an ID was received and passed
+// the policy checks; a ResultMessage follows once it has run.
+type AckMessage struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Command string `json:"command"`
+}
+
+// ResultMessage reports the outcome of every inbound command.
+type ResultMessage struct {
+	Type    string `json:"type"`
+	ID      string `json:"id,omitempty"`
+	Command string `json:"command"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ProtocolVersion is the version of the message protocol this client speaks.
+// It is bumped whenever a change would break an older server or client.
+const ProtocolVersion = 1
+
+// ErrIncompatibleProtocol is reported in Status.Err when the server refuses
+// the client's protocol version. The client stops reconnecting, since
+// retrying cannot succeed until the app is updated.
+var ErrIncompatibleProtocol = errors.New("protocol version not supported by the server")
+
+// ErrUnauthorized is reported in Status.Err when the server refuses the
+// session token and it could not be refreshed. The client stops
+// reconnecting; the user has to log in again.
+var ErrUnauthorized = errors.New("session token rejected by the server")
+
+// errLivenessTimeout is the cause of a drop after the server went silent.
+var errLivenessTimeout = errors.New("no response from server")
+
+// HelloMessage is the first message sent on every connection. It tells the
+// server what the client can do so that it only offers controls that work.
+type HelloMessage struct {
+	Type            string   `json:"type"`
+	ProtocolVersion int      `json:"protocolVersion"`
+	AppVersion      string   `json:"appVersion"`
+	OS              string   `json:"os"`
+	InputBackend    string   `json:"inputBackend"`
+	MessageTypes    []string `json:"messageTypes"`
+	AllowedCommands []string `json:"allowedCommands"`
+	AllowedKeys     []string `json:"allowedKeys"`
+	DeviceID        string   `json:"deviceId,omitempty"`
+	DeviceName      string   `json:"deviceName,omitempty"`
+	// PublicKey is the desktop's signing key, set when only signed commands
+	// are accepted.
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// DeviceInfoMessage tells the server the device was renamed while connected.
+type DeviceInfoMessage struct {
+	Type       string `json:"type"`
+	DeviceID   string `json:"deviceId"`
+	DeviceName string `json:"deviceName"`
+}
+
+// WelcomeMessage is the server's answer to an accepted hello. Timestamp is the
+// server's time in Unix milliseconds, against which the timestamps of
+// commands are checked.
+type WelcomeMessage struct {
+	Type            string `json:"type"`
+	ProtocolVersion int    `json:"protocolVersion"`
+	Timestamp       int64  `json:"ts,omitempty"`
+}
+
+// RejectedMessage is the server's answer to a hello it cannot serve, e.g.
+// because the protocol version is too old.
+type RejectedMessage struct {
+	Type               string `json:"type"`
+	Reason             string `json:"reason"`
+	MinProtocolVersion int    `json:"minProtocolVersion,omitempty"`
+}
+
+// Key actions for KeyCodeMessage. An empty action is a tap.
+const (
+	ActionTap    = "tap"
+	ActionHold   = "hold"   // press for DurationMs, then release
+	ActionRepeat = "repeat" // tap Count times, IntervalMs apart
+	ActionDown   = "down"   // press until a matching "up" arrives
+	ActionUp     = "up"
+)
+
+// KeyCodeMessage asks for a single key, optionally held with modifiers
+// ("keyCode": "key.m", "modifiers": ["mod.ctrl", "mod.shift"]), or for a
+// sequence of such keystrokes sent one after the other. Key names are the
+// logical names from the keys package; Windows VK_* names are accepted too.
+type KeyCodeMessage struct {
+	Type string `json:"type"`
+	// ID is echoed in the ack and the result and used to drop duplicate
+	// deliveries; Timestamp is the server's send time in Unix milliseconds,
+	// used to drop stale ones. Every command type carries both, and may
+	// carry a DeviceID to address a single desktop.
+	ID         string      `json:"id,omitempty"`
+	Timestamp  int64       `json:"ts,omitempty"`
+	KeyCode    string      `json:"keyCode"`
+	Modifiers  []string    `json:"modifiers,omitempty"`
+	Sequence   []KeyStroke `json:"sequence,omitempty"`
+	Action     string      `json:"action,omitempty"`
+	DurationMs int         `json:"durationMs,omitempty"`
+	Count      int         `json:"count,omitempty"`
+	IntervalMs int         `json:"intervalMs,omitempty"`
+	UserID     string      `json:"userId"`
+}
+
+type KeyStroke struct {
+	KeyCode   string   `json:"keyCode"`
+	Modifiers []string `json:"modifiers,omitempty"`
+}
+
+// Validate checks that every key in the message is a known key name.
+func (m KeyCodeMessage) Validate() error {
+	strokes := m.Strokes()
+	for _, stroke := range strokes {
+		for _, key := range append([]string{stroke.KeyCode}, stroke.Modifiers...) {
+			if err := keys.Validate(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TypeTextMessage asks for Text to be typed into the focused window.
+type TypeTextMessage struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	Timestamp int64  `json:"ts,omitempty"`
+	Text      string `json:"text"`
+	UserID    string `json:"userId"`
+}
+
+// MacroMessage asks for the macro called Name in config.yaml to be run.
+type MacroMessage struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	Timestamp int64  `json:"ts,omitempty"`
+	Name      string `json:"name"`
+	UserID    string `json:"userId"`
+}
+
+// Strokes returns the keystrokes to send, in order.
+func (m KeyCodeMessage) Strokes() []KeyStroke {
+	if len(m.Sequence) > 0 {
+		return m.Sequence
+	}
+	return []KeyStroke{{KeyCode: m.KeyCode, Modifiers: m.Modifiers}}
+}
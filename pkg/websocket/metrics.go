@@ -0,0 +1,185 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// latencySmoothing is the weight of the newest sample in the average latency.
+const latencySmoothing = 0.2
+
+// Metrics describes the quality of the connection, for the UI and for the
+// server's diagnostics.
+type Metrics struct {
+	Connected bool
+	// Latency is the round trip of the last ping; AvgLatency smooths it
+	// over recent pings.
+	Latency    time.Duration
+	AvgLatency time.Duration
+	// Uptime is how long the current connection has been up; TotalUptime
+	// adds up every connection since the client was created.
+	Uptime      time.Duration
+	TotalUptime time.Duration
+	Connects    int
+	Reconnects  int
+	Disconnects int
+	// DisconnectReasons counts disconnections by reason, e.g. "timeout".
+	DisconnectReasons map[string]int
+	LastDisconnect    string
+	LastPong          time.Time
+}
+
+// metricsState is the client's bookkeeping behind Metrics, guarded by c.mu.
+type metricsState struct {
+	latency     time.Duration
+	avgLatency  time.Duration
+	upSince     time.Time
+	totalUptime time.Duration
+	connects    int
+	disconnects int
+	reasons     map[string]int
+	lastReason  string
+}
+
+// MetricsMessage answers a "getMetrics" request from the server. Durations
+// are in milliseconds.
+type MetricsMessage struct {
+	Type              string         `json:"type"`
+	ID                string         `json:"id,omitempty"`
+	LatencyMs         int64          `json:"latencyMs"`
+	AvgLatencyMs      int64          `json:"avgLatencyMs"`
+	UptimeMs          int64          `json:"uptimeMs"`
+	TotalUptimeMs     int64          `json:"totalUptimeMs"`
+	Connects          int            `json:"connects"`
+	Reconnects        int            `json:"reconnects"`
+	Disconnects       int            `json:"disconnects"`
+	DisconnectReasons map[string]int `json:"disconnectReasons"`
+	LastDisconnect    string         `json:"lastDisconnect,omitempty"`
+}
+
+// Metrics returns a snapshot of the connection metrics.
+func (c *Client) Metrics() Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.metrics
+	metrics := Metrics{
+		Connected:         !c.closed,
+		Latency:           m.latency,
+		AvgLatency:        m.avgLatency,
+		TotalUptime:       m.totalUptime,
+		Connects:          m.connects,
+		Reconnects:        max(m.connects-1, 0),
+		Disconnects:       m.disconnects,
+		DisconnectReasons: make(map[string]int, len(m.reasons)),
+		LastDisconnect:    m.lastReason,
+		LastPong:          c.lastPong,
+	}
+	if !m.upSince.IsZero() {
+		metrics.Uptime = time.Since(m.upSince)
+		metrics.TotalUptime += metrics.Uptime
+	}
+	for reason, n := range m.reasons {
+		metrics.DisconnectReasons[reason] = n
+	}
+	return metrics
+}
+
+func (c *Client) recordConnectLocked() {
+	c.metrics.connects++
+	c.metrics.upSince = time.Now()
+}
+
+// recordDisconnectLocked ends the uptime of the current connection. A nil
+// cause means the client closed the connection itself, which is not counted
+// as a disconnection.
+func (c *Client) recordDisconnectLocked(cause error) {
+	if !c.metrics.upSince.IsZero() {
+		c.metrics.totalUptime += time.Since(c.metrics.upSince)
+		c.metrics.upSince = time.Time{}
+	}
+	if cause == nil {
+		return
+	}
+
+	reason := disconnectReason(cause)
+	if c.metrics.reasons == nil {
+		c.metrics.reasons = make(map[string]int)
+	}
+	c.metrics.disconnects++
+	c.metrics.reasons[reason]++
+	c.metrics.lastReason = reason
+}
+
+// recordPong measures the round trip of a ping sent with pingPayload.
+func (c *Client) recordPong(payload string) {
+	sent, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return
+	}
+	rtt := time.Since(time.Unix(0, sent))
+	if rtt < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.metrics.latency = rtt
+	if c.metrics.avgLatency == 0 {
+		c.metrics.avgLatency = rtt
+	} else {
+		c.metrics.avgLatency += time.Duration(latencySmoothing * float64(rtt-c.metrics.avgLatency))
+	}
+}
+
+// pingPayload stamps a ping with its send time; the server echoes it in the
+// pong.
+func pingPayload() []byte {
+	return []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// disconnectReason sorts a disconnection cause into a few coarse reasons.
+func disconnectReason(err error) string {
+	var closeErr *websocket.CloseError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &closeErr) && closeErr.Code == websocket.CloseAbnormalClosure:
+		return "connection lost"
+	case errors.As(err, &closeErr):
+		return "closed by server (" + strconv.Itoa(closeErr.Code) + ")"
+	case errors.Is(err, ErrIncompatibleProtocol):
+		return "rejected"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, errLivenessTimeout):
+		return "timeout"
+	default:
+		return "network error"
+	}
+}
+
+func (c *Client) sendMetrics(id string) {
+	m := c.Metrics()
+	msg := MetricsMessage{
+		Type:              "metrics",
+		ID:                id,
+		LatencyMs:         m.Latency.Milliseconds(),
+		AvgLatencyMs:      m.AvgLatency.Milliseconds(),
+		UptimeMs:          m.Uptime.Milliseconds(),
+		TotalUptimeMs:     m.TotalUptime.Milliseconds(),
+		Connects:          m.Connects,
+		Reconnects:        m.Reconnects,
+		Disconnects:       m.Disconnects,
+		DisconnectReasons: m.DisconnectReasons,
+		LastDisconnect:    m.LastDisconnect,
+	}
+	if err := c.sendJSON(msg); err != nil {
+		log.Printf("Error sending metrics: %v", err)
+	}
+}
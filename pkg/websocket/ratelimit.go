@@ -0,0 +1,222 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"mediacontrol/pkg/internal/defaults"
+	"mediacontrol/pkg/keys"
+)
+
+// volumeClass is the rate-limit class for keyCode commands that only press
+// volume keys, which are naturally sent in quick bursts.
+const volumeClass = "keyCode:volume"
+
+// unknownClass is the rate-limit class shared by message types with no
+// handler.
+const unknownClass = "unknown"
+
+const maxRateBuckets = 1024
+
+var defaultRateLimits = map[string]RateLimit{
+	"keyCode":    {PerSecond: 10, Burst: 20},
+	volumeClass:  {PerSecond: 20, Burst: 40},
+	"typeText":   {PerSecond: 2, Burst: 5},
+	"macro":      {PerSecond: 1, Burst: 3},
+	"getMetrics": {PerSecond: 1, Burst: 5},
+	unknownClass: {PerSecond: 1, Burst: 5},
+}
+
+// rateLimiter keeps a token bucket per sender and command class, and locks a
+// sender out for a while once it keeps exceeding its limits.
+type rateLimiter struct {
+	config  RateLimitConfig
+	lockout time.Duration
+
+	buckets map[string]*bucket
+	senders map[string]*senderState
+	mu      sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type senderState struct {
+	strikes     int
+	firstStrike time.Time
+	lockedUntil time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config:  cfg,
+		lockout: defaults.Millis(cfg.LockoutMs, defaultLockout),
+		buckets: make(map[string]*bucket),
+		senders: make(map[string]*senderState),
+	}
+}
+
+func (r *rateLimiter) limit(class string) RateLimit {
+	limit, ok := r.config.Limits[class]
+	if !ok {
+		limit, ok = defaultRateLimits[class]
+	}
+	if !ok || limit.PerSecond <= 0 {
+		limit.PerSecond = defaultRateLimits["keyCode"].PerSecond
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = max(int(limit.PerSecond), 1)
+	}
+	return limit
+}
+
+// allow takes a token from the sender's bucket for class. When it fails it
+// returns how long the sender has to wait, and whether this rejection has
+// just locked the sender out.
+func (r *rateLimiter) allow(sender, class string, now time.Time) (wait time.Duration, lockedOut bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := r.senders[sender]
+	if state == nil {
+		state = &senderState{}
+		r.senders[sender] = state
+	}
+	if now.Before(state.lockedUntil) {
+		return state.lockedUntil.Sub(now), false
+	}
+
+	limit := r.limit(class)
+	key := sender + "\x00" + class
+	b := r.buckets[key]
+	if b == nil {
+		if len(r.buckets) >= maxRateBuckets {
+			r.prune(now)
+		}
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		r.buckets[key] = b
+	}
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*limit.PerSecond, float64(limit.Burst))
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, false
+	}
+
+	// Count rejections over the lockout period; too many of them means the
+	// sender is flooding rather than just bursting.
+	if now.Sub(state.firstStrike) > r.lockout {
+		state.strikes = 0
+		state.firstStrike = now
+	}
+	state.strikes++
+	if state.strikes >= defaults.Int(r.config.LockoutThreshold, defaultLockoutThreshold) {
+		state.strikes = 0
+		state.lockedUntil = now.Add(r.lockout)
+		return r.lockout, true
+	}
+	return time.Duration((1 - b.tokens) / limit.PerSecond * float64(time.Second)), false
+}
+
+// prune forgets buckets that have refilled completely, and senders that are
+// neither locked out nor collecting strikes, so that a stream of made-up
+// sender names cannot grow the maps without bound.
+func (r *rateLimiter) prune(now time.Time) {
+	for key, b := range r.buckets {
+		class := key[strings.IndexByte(key, 0)+1:]
+		limit := r.limit(class)
+		if b.tokens+now.Sub(b.last).Seconds()*limit.PerSecond >= float64(limit.Burst) {
+			delete(r.buckets, key)
+		}
+	}
+	for sender, state := range r.senders {
+		if now.After(state.lockedUntil) && now.Sub(state.firstStrike) > r.lockout {
+			delete(r.senders, sender)
+		}
+	}
+}
+
+// rateClass returns the class a message is limited under. Message types
+// without a handler share one class, so that made-up types cannot each get a
+// fresh bucket.
+func (c *Client) rateClass(req *Request) string {
+	if req.Type == "getMetrics" {
+		return req.Type
+	}
+	if _, _, ok := c.router.lookup(req.Type); !ok {
+		return unknownClass
+	}
+	if req.Type != "keyCode" {
+		return req.Type
+	}
+
+	// The payload is not decoded yet; a keyCode that does not decode is
+	// limited as a regular keyCode and rejected later.
+	var msg KeyCodeMessage
+	if err := json.Unmarshal(req.Raw, &msg); err != nil {
+		return req.Type
+	}
+	for _, stroke := range msg.Strokes() {
+		for _, key := range append([]string{stroke.KeyCode}, stroke.Modifiers...) {
+			if !strings.HasPrefix(keys.Canonical(key), "volume.") {
+				return req.Type
+			}
+		}
+	}
+	return volumeClass
+}
+
+// limitRate rejects messages over the sender's rate limits without decoding,
+// running or logging them, and notifies the status handler of a lockout.
+func (c *Client) limitRate(next Handler) Handler {
+	return func(req *Request) error {
+		if c.config.RateLimit.Disabled {
+			return next(req)
+		}
+
+		sender := req.Sender
+		if sender == "" {
+			sender = req.UserID
+		}
+		wait, lockedOut := c.limiter.allow(sender, c.rateClass(req), time.Now())
+		if lockedOut {
+			log.Printf("Too many commands from %s, ignoring it for %v", sender, wait)
+			c.notifyLockout(time.Now().Add(wait))
+		}
+		if wait > 0 {
+			return &StatusError{
+				Status: ResultRateLimited,
+				Err:    fmt.Errorf("rate limit exceeded, retry in %v", wait.Round(time.Millisecond)),
+			}
+		}
+		return next(req)
+	}
+}
+
+// notifyLockout reports the lockout through the status handler, and reports
+// the connection again once it is over.
+func (c *Client) notifyLockout(until time.Time) {
+	c.mu.Lock()
+	connected, attempt := !c.closed, c.attempt
+	c.mu.Unlock()
+	if !connected {
+		return
+	}
+	c.setStatus(Status{Connected: true, Attempt: attempt, LockedUntil: until})
+
+	time.AfterFunc(time.Until(until), func() {
+		c.mu.Lock()
+		connected, attempt := !c.closed, c.attempt
+		c.mu.Unlock()
+		if connected {
+			c.setStatus(Status{Connected: true, Attempt: attempt})
+		}
+	})
+}
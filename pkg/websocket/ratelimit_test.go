@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"mediacontrol/pkg/websocket/wstest"
+)
+
+func TestRateLimiter(t *testing.T) {
+	type step struct {
+		at        time.Duration // since the start
+		sender    string
+		class     string
+		n         int // times to send; 0 means once
+		allowed   bool
+		lockedOut bool
+	}
+	tests := []struct {
+		name  string
+		cfg   RateLimitConfig
+		steps []step
+	}{
+		{
+			name: "burst and refill",
+			cfg:  RateLimitConfig{Limits: map[string]RateLimit{"keyCode": {PerSecond: 10, Burst: 2}}},
+			steps: []step{
+				{at: 0, sender: "phone", class: "keyCode", n: 2, allowed: true},
+				{at: 0, sender: "phone", class: "keyCode"},
+				{at: 100 * time.Millisecond, sender: "phone", class: "keyCode", allowed: true},
+				{at: 100 * time.Millisecond, sender: "tablet", class: "keyCode", n: 2, allowed: true},
+			},
+		},
+		{
+			name: "volume burst",
+			steps: []step{
+				{sender: "phone", class: volumeClass, n: 40, allowed: true},
+				{sender: "phone", class: volumeClass},
+				// Other keys have their own bucket.
+				{sender: "phone", class: "keyCode", n: 20, allowed: true},
+				{sender: "phone", class: "keyCode"},
+			},
+		},
+		{
+			name: "unknown",
+			steps: []step{
+				{sender: "phone", class: unknownClass, n: 5, allowed: true},
+				{sender: "phone", class: unknownClass},
+			},
+		},
+		{
+			name: "lockout and expiry",
+			cfg: RateLimitConfig{
+				Limits:           map[string]RateLimit{"keyCode": {PerSecond: 1, Burst: 1}},
+				LockoutThreshold: 3,
+				LockoutMs:        1000,
+			},
+			steps: []step{
+				{sender: "phone", class: "keyCode", allowed: true},
+				{sender: "phone", class: "keyCode", n: 2},
+				{sender: "phone", class: "keyCode", lockedOut: true},
+				// Locked out of every class, but other senders go on.
+				{at: 500 * time.Millisecond, sender: "phone", class: "macro"},
+				{at: 500 * time.Millisecond, sender: "tablet", class: "keyCode", allowed: true},
+				{at: 1001 * time.Millisecond, sender: "phone", class: "keyCode", allowed: true},
+			},
+		},
+		{
+			name: "spread out strikes",
+			cfg: RateLimitConfig{
+				Limits:           map[string]RateLimit{"keyCode": {PerSecond: 1, Burst: 1}},
+				LockoutThreshold: 3,
+				LockoutMs:        1000,
+			},
+			steps: []step{
+				{sender: "phone", class: "keyCode", allowed: true},
+				{sender: "phone", class: "keyCode", n: 2},
+				{at: 1500 * time.Millisecond, sender: "phone", class: "keyCode", allowed: true},
+				{at: 1500 * time.Millisecond, sender: "phone", class: "keyCode", n: 2},
+			},
+		},
+	}
+
+	start := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRateLimiter(tt.cfg)
+			for i, s := range tt.steps {
+				for range max(s.n, 1) {
+					wait, lockedOut := r.allow(s.sender, s.class, start.Add(s.at))
+					if allowed := wait == 0; allowed != s.allowed || lockedOut != s.lockedOut {
+						t.Fatalf("step %d (%s %s at %v): allowed %v, locked out %v; want %v, %v",
+							i, s.sender, s.class, s.at, allowed, lockedOut, s.allowed, s.lockedOut)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRateClass(t *testing.T) {
+	c := NewClient("http://localhost", "token", "user")
+	c.SetKeyPressHandler(func(KeyCodeMessage) error { return nil })
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{`{"type":"keyCode","keyCode":"volume.up"}`, volumeClass},
+		{`{"type":"keyCode","keyCode":"VK_VOLUME_DOWN"}`, volumeClass},
+		{`{"type":"keyCode","sequence":[{"keyCode":"volume.up"},{"keyCode":"volume.mute"}]}`, volumeClass},
+		{`{"type":"keyCode","keyCode":"volume.up","modifiers":["mod.ctrl"]}`, "keyCode"},
+		{`{"type":"keyCode","sequence":[{"keyCode":"volume.up"},{"keyCode":"media.next"}]}`, "keyCode"},
+		{`{"type":"keyCode","keyCode":"media.next"}`, "keyCode"},
+		{`{"type":"keyCode","keyCode":42}`, "keyCode"},
+		{`{"type":"getMetrics"}`, "getMetrics"},
+		// Types without a handler share one class.
+		{`{"type":"typeText","text":"hi"}`, unknownClass},
+		{`{"type":"teleport"}`, unknownClass},
+	}
+	for _, tt := range tests {
+		var env envelope
+		if err := json.Unmarshal([]byte(tt.raw), &env); err != nil {
+			t.Fatal(err)
+		}
+		req := &Request{Type: env.Type, Raw: []byte(tt.raw)}
+		if got := c.rateClass(req); got != tt.want {
+			t.Errorf("rateClass(%s) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitLockoutStatus(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+
+	statuses := make(chan Status, 10)
+	c := NewClient(srv.URL, "token", "user")
+	c.SetConfig(Config{RateLimit: RateLimitConfig{
+		Limits:           map[string]RateLimit{"keyCode": {PerSecond: 0.01, Burst: 1}},
+		LockoutThreshold: 2,
+		LockoutMs:        200,
+	}})
+	c.SetKeyPressHandler(func(KeyCodeMessage) error { return nil })
+	c.SetConnectionStatusHandler(func(s Status) { statuses <- s })
+	go c.Run(t.Context())
+
+	next := func() Status {
+		t.Helper()
+		select {
+		case s := <-statuses:
+			return s
+		case <-time.After(5 * time.Second):
+			t.Fatal("no status reported")
+			return Status{}
+		}
+	}
+	if s := next(); !s.Connected {
+		t.Fatalf("first status = %+v, want connected", s)
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		srv.SendKeyCode("user", id, "media.next")
+	}
+	s := next()
+	if !s.Connected || s.LockedUntil.IsZero() {
+		t.Fatalf("status after the flood = %+v, want connected and locked out", s)
+	}
+	if s = next(); !s.Connected || !s.LockedUntil.IsZero() {
+		t.Errorf("status after the lockout = %+v, want connected and no lockout", s)
+	}
+}
@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"log"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"mediacontrol/pkg/internal/defaults"
+)
+
+// backoff returns the delay before reconnection attempt number attempt
+// (starting at 1).
+func (r ReconnectConfig) backoff(attempt int) time.Duration {
+	initial := defaults.Millis(r.InitialIntervalMs, defaultInitialInterval)
+	maxInterval := defaults.Millis(r.MaxIntervalMs, defaultMaxInterval)
+
+	multiplier := r.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultMultiplier
+	}
+	jitter := r.Jitter
+	if jitter <= 0 || jitter > 1 {
+		jitter = defaultJitter
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	delay = math.Min(delay, float64(maxInterval))
+	delay *= 1 + jitter*(2*rand.Float64()-1)
+	return time.Duration(delay)
+}
+
+// scheduleReconnect reports the client offline and, unless it was closed or
+// reconnection is disabled, arms a timer for the next attempt.
+func (c *Client) scheduleReconnect(cause error) {
+	c.mu.Lock()
+	if c.stopped || c.config.Reconnect.Disabled {
+		attempt := c.attempt
+		c.mu.Unlock()
+		c.setStatus(Status{Attempt: attempt, Err: cause})
+		return
+	}
+
+	stableAfter := defaults.Millis(c.config.Reconnect.StableAfterMs, defaultStableAfter)
+	if !c.connectedAt.IsZero() && time.Since(c.connectedAt) >= stableAfter {
+		c.attempt = 0
+	}
+	c.connectedAt = time.Time{}
+
+	c.attempt++
+	attempt := c.attempt
+	delay := c.config.Reconnect.backoff(attempt)
+	next := time.Now().Add(delay)
+
+	c.cancelRetryLocked()
+	c.retryTimer = time.AfterFunc(delay, c.retry)
+	c.mu.Unlock()
+
+	log.Printf("WebSocket disconnected (%v), reconnection attempt %d in %v", cause, attempt, delay.Round(time.Millisecond))
+	c.setStatus(Status{Attempt: attempt, NextRetry: next, Err: cause})
+}
+
+func (c *Client) retry() {
+	c.mu.Lock()
+	stopped := c.stopped
+	c.retryTimer = nil
+	c.mu.Unlock()
+
+	if stopped {
+		return
+	}
+	if err := c.connect(); err != nil {
+		log.Printf("Error reconnecting to WebSocket: %v", err)
+	}
+}
+
+func (c *Client) cancelRetryLocked() {
+	if c.retryTimer != nil {
+		c.retryTimer.Stop()
+		c.retryTimer = nil
+	}
+}
+
+func (c *Client) setStatus(status Status) {
+	if c.onStatus != nil {
+		c.onStatus(status)
+	}
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"mediacontrol/pkg/internal/defaults"
+)
+
+// replayGuard remembers the IDs of recent commands so that a retried or
+// replayed delivery is not run twice.
+type replayGuard struct {
+	maxAge  time.Duration
+	require bool
+
+	seen  map[string]bool
+	order []string // ring buffer of the IDs in seen, oldest at next
+	next  int
+
+	// offset is how far the server's clock is ahead of the local one, as
+	// estimated from the welcome. Timestamps are only checked for
+	// staleness once it is known, so that a desktop with a wrong clock does
+	// not reject every command.
+	offset time.Duration
+	synced bool
+	mu     sync.Mutex
+}
+
+func newReplayGuard(cfg ReplayConfig) *replayGuard {
+	size := defaults.Int(cfg.WindowSize, defaultReplayWindow)
+	return &replayGuard{
+		maxAge:  defaults.Millis(cfg.MaxAgeMs, defaultReplayMaxAge),
+		require: cfg.Require,
+		seen:    make(map[string]bool, size),
+		order:   make([]string, size),
+	}
+}
+
+// syncClock records the server's time, received at now. The estimate is kept
+// across reconnections and updated by every welcome.
+func (g *replayGuard) syncClock(serverTime, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.offset = serverTime.Sub(now)
+	g.synced = true
+}
+
+// check rejects a command whose timestamp is too far from the server's
+// current time, then records its ID and rejects it if the ID was already
+// seen.
+func (g *replayGuard) check(req *Request, now time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if req.Timestamp.IsZero() {
+		if g.require {
+			return fmt.Errorf("command has no timestamp")
+		}
+	} else if g.synced {
+		age := now.Add(g.offset).Sub(req.Timestamp)
+		if age > g.maxAge || age < -g.maxAge {
+			return fmt.Errorf("stale command: timestamp is %v off", age.Round(time.Millisecond))
+		}
+	}
+
+	if req.ID == "" {
+		if g.require {
+			return fmt.Errorf("command has no id")
+		}
+		return nil
+	}
+
+	if g.seen[req.ID] {
+		return &StatusError{Status: ResultDuplicate, Err: fmt.Errorf("duplicate command %s", req.ID)}
+	}
+	if old := g.order[g.next]; old != "" {
+		delete(g.seen, old)
+	}
+	g.order[g.next] = req.ID
+	g.next = (g.next + 1) % len(g.order)
+	g.seen[req.ID] = true
+	return nil
+}
+
+// checkReplay rejects duplicate and stale commands before they reach the
+// policy and the handlers.
+func (c *Client) checkReplay(next Handler) Handler {
+	return func(req *Request) error {
+		if err := c.replay.check(req, time.Now()); err != nil {
+			return err
+		}
+		return next(req)
+	}
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"mediacontrol/pkg/websocket/wstest"
+)
+
+func keyCodeAt(id string, ts time.Time) map[string]any {
+	return map[string]any{"type": "keyCode", "id": id, "userId": "user", "keyCode": "media.next", "ts": ts.UnixMilli()}
+}
+
+func checkResults(t *testing.T, got map[string]ResultMessage, want map[string]string) {
+	t.Helper()
+	for id, status := range want {
+		if got[id].Status != status {
+			t.Errorf("result for %q = %+v, want %q", id, got[id], status)
+		}
+	}
+}
+
+func TestReplayDuplicate(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	startClient(t, srv, Config{Replay: ReplayConfig{WindowSize: 3}})
+
+	srv.SendKeyCode("user", "a", "media.next")
+	checkResults(t, results(t, srv, "s1"), map[string]string{"a": ResultOK})
+
+	srv.SendKeyCode("user", "a", "media.next")
+	checkResults(t, results(t, srv, "s2"), map[string]string{"a": ResultDuplicate})
+
+	// b and c push a and s1 out of the window of three ids.
+	srv.SendKeyCode("user", "b", "media.next")
+	srv.SendKeyCode("user", "c", "media.next")
+	srv.SendKeyCode("user", "a", "media.next")
+	checkResults(t, results(t, srv, "s3"), map[string]string{"b": ResultOK, "c": ResultOK, "a": ResultOK})
+}
+
+func TestReplayStale(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	startClient(t, srv, Config{Replay: ReplayConfig{MaxAgeMs: 1000}})
+
+	now := srv.Now()
+	srv.Send(keyCodeAt("old", now.Add(-2*time.Second)))
+	srv.Send(keyCodeAt("future", now.Add(2*time.Second)))
+	srv.Send(keyCodeAt("recent", now.Add(-500*time.Millisecond)))
+	checkResults(t, results(t, srv, "ok"), map[string]string{
+		"old":    ResultDenied,
+		"future": ResultDenied,
+		"recent": ResultOK,
+	})
+}
+
+func TestReplayClockSkew(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	// The desktop's clock is well over max_age_ms off the server's.
+	srv.SetClockSkew(-31 * time.Second)
+	startClient(t, srv, Config{Replay: ReplayConfig{MaxAgeMs: 1000}})
+
+	srv.Send(keyCodeAt("old", srv.Now().Add(-2*time.Second)))
+	checkResults(t, results(t, srv, "ok"), map[string]string{
+		"old": ResultDenied,
+		"ok":  ResultOK,
+	})
+}
+
+func TestReplayRequire(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	startClient(t, srv, Config{Replay: ReplayConfig{Require: true}})
+
+	srv.Send(map[string]any{"type": "keyCode", "userId": "user", "keyCode": "media.next", "ts": srv.Now().UnixMilli()})
+	srv.Send(map[string]any{"type": "keyCode", "id": "no ts", "userId": "user", "keyCode": "media.next"})
+	checkResults(t, results(t, srv, "ok"), map[string]string{
+		"":      ResultDenied,
+		"no ts": ResultDenied,
+		"ok":    ResultOK,
+	})
+}
@@ -0,0 +1,364 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrIgnored is returned by middleware to drop a command silently, without
+// reporting a result, e.g. because it is addressed to another user.
+var ErrIgnored = errors.New("message not addressed to this client")
+
+// Request is an inbound command on its way through the middleware chain.
+type Request struct {
+	Type      string
+	ID        string
+	UserID    string
+	Sender    string    // the sending device, if the server says
+	DeviceID  string    // the desktop the command is for; empty means any
+	Timestamp time.Time // zero if the server sent none
+	// Payload is the decoded message, e.g. a KeyCodeMessage.
+	Payload any
+	Raw     []byte
+
+	accepted bool
+}
+
+// Handler runs a command. Returning an error before the route's handler has
+// been reached reports the command as denied; an error from the route's
+// handler itself reports it as failed.
+type Handler func(req *Request) error
+
+// Middleware wraps a handler, e.g. to log, authorize or rate-limit commands.
+type Middleware func(next Handler) Handler
+
+type route struct {
+	decode func(raw []byte) (any, error)
+	handle Handler
+}
+
+// Router maps message types to typed handlers. Middleware added with
+// UseEnvelope runs first, on every inbound message; middleware added with Use
+// runs around commands that were decoded. Both run in the order added.
+type Router struct {
+	routes     map[string]route
+	envelope   []Middleware
+	middleware []Middleware
+	mu         sync.RWMutex
+}
+
+func NewRouter() *Router {
+	return &Router{routes: make(map[string]route)}
+}
+
+// Handle registers handler for msgType. The payload is decoded into T and, if
+// T has a Validate method, validated before the middleware added with Use
+// runs.
+func Handle[T any](r *Router, msgType string, handler func(T) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.routes[msgType] = route{
+		decode: func(raw []byte) (any, error) {
+			var msg T
+			if err := json.Unmarshal(raw, &msg); err != nil {
+				return nil, err
+			}
+			if v, ok := any(msg).(validator); ok {
+				if err := v.Validate(); err != nil {
+					return nil, err
+				}
+			}
+			return msg, nil
+		},
+		handle: func(req *Request) error {
+			return handler(req.Payload.(T))
+		},
+	}
+}
+
+func (r *Router) Use(middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// UseEnvelope adds middleware that runs before the message type is looked up
+// and the payload decoded, so that it also covers unknown and malformed
+// messages. Only the envelope fields of the Request are set; Payload is nil.
+func (r *Router) UseEnvelope(middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.envelope = append(r.envelope, middleware...)
+}
+
+// Types returns the registered message types, sorted.
+func (r *Router) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.routes))
+	for t := range r.routes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func (r *Router) lookup(msgType string) (route, []Middleware, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rt, ok := r.routes[msgType]
+	return rt, r.middleware, ok
+}
+
+func (r *Router) envelopeMiddleware() []Middleware {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.envelope
+}
+
+// validator is implemented by messages that can check their own payload.
+type validator interface {
+	Validate() error
+}
+
+// envelope holds the fields shared by every inbound command.
+type envelope struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	UserID    string `json:"userId"`
+	Sender    string `json:"sender"`
+	DeviceID  string `json:"deviceId"`
+	Timestamp int64  `json:"ts"`
+}
+
+// dispatch runs one inbound frame through the envelope middleware and queues
+// it for the command worker, which routes it to its handler. The outcome is
+// reported to the server either way.
+func (c *Client) dispatch(message []byte) {
+	var env envelope
+	if err := json.Unmarshal(message, &env); err != nil {
+		log.Printf("Error parsing message: %v", err)
+		return
+	}
+
+	// The server's answers to the hello are not addressed to a user.
+	switch env.Type {
+	case "welcome", "rejected":
+		c.handleControl(env, message)
+		return
+	}
+
+	req := &Request{
+		Type:     env.Type,
+		ID:       env.ID,
+		UserID:   env.UserID,
+		Sender:   env.Sender,
+		DeviceID: env.DeviceID,
+		Raw:      message,
+	}
+	if env.Timestamp != 0 {
+		req.Timestamp = time.UnixMilli(env.Timestamp)
+	}
+
+	handler := c.enqueue
+	middleware := c.router.envelopeMiddleware()
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	if err := handler(req); !errors.Is(err, errQueued) {
+		c.report(req, err)
+	}
+}
+
+// errQueued tells dispatch that a message passed the envelope middleware and
+// was handed to the command worker, which reports its outcome.
+var errQueued = errors.New("command queued")
+
+// commandQueueSize is how many commands may wait while another one runs.
+const commandQueueSize = 16
+
+// enqueue hands a message to the command worker. Holds, repeats, typing and
+// macros take seconds; running them on the read pump would stop it from
+// reading pongs and make a healthy connection look dead.
+func (c *Client) enqueue(req *Request) error {
+	select {
+	case c.commands <- req:
+		return errQueued
+	default:
+		return &StatusError{Status: ResultRateLimited, Err: errors.New("too many commands waiting to run")}
+	}
+}
+
+// runCommands routes queued messages one at a time, in the order they
+// arrived, until ctx is done.
+func (c *Client) runCommands(ctx context.Context) {
+	defer c.pumps.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-c.commands:
+			c.report(req, c.route(req))
+		}
+	}
+}
+
+// report sends the result matching the outcome of a message.
+func (c *Client) report(req *Request, err error) {
+	var statusErr *StatusError
+	switch {
+	case errors.Is(err, ErrIgnored):
+	case errors.As(err, &statusErr):
+		c.sendResult(req, statusErr.Status, err)
+	case err == nil:
+		c.sendResult(req, ResultOK, nil)
+	case !req.accepted:
+		c.sendResult(req, ResultDenied, err)
+	default:
+		c.sendResult(req, ResultError, err)
+	}
+}
+
+// route runs a message that passed the envelope middleware: it answers
+// requests for metrics, and decodes commands and runs them through the
+// middleware and the handler registered for their type.
+func (c *Client) route(req *Request) error {
+	if req.Type == "getMetrics" {
+		c.sendMetrics(req.ID)
+		// The metrics message is the answer; no result follows.
+		return ErrIgnored
+	}
+
+	rt, middleware, ok := c.router.lookup(req.Type)
+	if !ok {
+		log.Printf("Received unknown message type %q", req.Type)
+		return &StatusError{Status: ResultUnsupported, Err: fmt.Errorf("unknown message type %q", req.Type)}
+	}
+
+	payload, err := rt.decode(req.Raw)
+	if err != nil {
+		log.Printf("Invalid %s message: %v", req.Type, err)
+		return &StatusError{Status: ResultError, Err: err}
+	}
+	req.Payload = payload
+
+	handler := func(req *Request) error {
+		req.accepted = true
+		if req.ID != "" {
+			if err := c.sendJSON(AckMessage{Type: "ack", ID: req.ID, Command: req.Type}); err != nil {
+				log.Printf("Error acknowledging %s message: %v", req.Type, err)
+			}
+		}
+		return rt.handle(req)
+	}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler(req)
+}
+
+func (c *Client) handleControl(env envelope, message []byte) {
+	switch env.Type {
+	case "welcome":
+		var welcome WelcomeMessage
+		if err := json.Unmarshal(message, &welcome); err != nil {
+			log.Printf("Error parsing welcome message: %v", err)
+			return
+		}
+		log.Printf("Server accepted hello (server protocol version %d)", welcome.ProtocolVersion)
+		if welcome.Timestamp != 0 {
+			c.replay.syncClock(time.UnixMilli(welcome.Timestamp), time.Now())
+		} else {
+			log.Printf("Server sent no time; command timestamps are not checked")
+		}
+	case "rejected":
+		var rejected RejectedMessage
+		if err := json.Unmarshal(message, &rejected); err != nil {
+			log.Printf("Error parsing rejected message: %v", err)
+		}
+		c.reject(rejected)
+	}
+}
+
+// LoggingMiddleware logs every command with its outcome and duration.
+func LoggingMiddleware(next Handler) Handler {
+	return func(req *Request) error {
+		start := time.Now()
+		err := next(req)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		switch {
+		case errors.Is(err, ErrIgnored):
+		case err != nil:
+			log.Printf("Command %s (id %q) failed after %v: %v", req.Type, req.ID, elapsed, err)
+		default:
+			log.Printf("Command %s (id %q) done in %v", req.Type, req.ID, elapsed)
+		}
+		return err
+	}
+}
+
+// AuthMiddleware drops commands addressed to a user other than userID.
+func AuthMiddleware(userID string) Middleware {
+	return func(next Handler) Handler {
+		return func(req *Request) error {
+			if req.UserID != userID {
+				log.Printf("Received message from different user ID: %s (expected: %s)", req.UserID, userID)
+				return ErrIgnored
+			}
+			return next(req)
+		}
+	}
+}
+
+// checkTarget drops commands addressed to another of the user's devices.
+func (c *Client) checkTarget(next Handler) Handler {
+	return func(req *Request) error {
+		if req.DeviceID != "" {
+			c.mu.Lock()
+			deviceID := c.deviceID
+			c.mu.Unlock()
+			if req.DeviceID != deviceID {
+				return ErrIgnored
+			}
+		}
+		return next(req)
+	}
+}
+
+// checkSignature denies commands the client's verifier rejects.
+func (c *Client) checkSignature(next Handler) Handler {
+	return func(req *Request) error {
+		if c.verifier != nil {
+			if err := c.verifier.Verify(req.Raw); err != nil {
+				return fmt.Errorf("invalid signature: %v", err)
+			}
+		}
+		return next(req)
+	}
+}
+
+// checkPolicy denies commands the client's policy does not allow.
+func (c *Client) checkPolicy(next Handler) Handler {
+	return func(req *Request) error {
+		if c.policy != nil {
+			if err := c.policy.Check(req.Payload); err != nil {
+				return err
+			}
+		}
+		return next(req)
+	}
+}
@@ -0,0 +1,161 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+
+	"mediacontrol/pkg/websocket/wstest"
+)
+
+// startClient connects a client for user "user" to srv and waits for its
+// hello.
+func startClient(t *testing.T, srv *wstest.Server, config Config) *Client {
+	t.Helper()
+	c := NewClient(srv.URL, "token", "user")
+	c.SetConfig(config)
+	c.SetKeyPressHandler(func(KeyCodeMessage) error { return nil })
+	go c.Run(t.Context())
+
+	if _, err := srv.WaitFrame("hello", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// results sends a keyCode with the sentinel id and returns the results the
+// server received up to and including the sentinel's.
+func results(t *testing.T, srv *wstest.Server, sentinel string) map[string]ResultMessage {
+	t.Helper()
+	if err := srv.SendKeyCode("user", sentinel, "media.next"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]ResultMessage)
+	for {
+		frame, err := srv.WaitFrame("result", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result ResultMessage
+		if err := frame.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		got[result.ID] = result
+		if result.ID == sentinel {
+			return got
+		}
+	}
+}
+
+func TestDispatchResults(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	startClient(t, srv, Config{})
+
+	srv.Send(map[string]any{"type": "teleport", "id": "unknown", "userId": "user"})
+	srv.Send(map[string]any{"type": "keyCode", "id": "invalid", "userId": "user", "keyCode": "key.nope"})
+	srv.Send(map[string]any{"type": "keyCode", "id": "foreign", "userId": "someone else", "keyCode": "media.next"})
+	srv.Send(map[string]any{"type": "teleport", "id": "foreign unknown", "userId": "someone else"})
+	srv.Send(map[string]any{"type": "keyCode", "id": "malformed", "userId": "someone else", "keyCode": 42})
+
+	got := results(t, srv, "ok")
+	want := map[string]string{
+		"unknown": ResultUnsupported,
+		"invalid": ResultError,
+		"ok":      ResultOK,
+	}
+	for id, status := range want {
+		if got[id].Status != status {
+			t.Errorf("result for %s = %q, want %q", id, got[id].Status, status)
+		}
+	}
+	for _, id := range []string{"foreign", "foreign unknown", "malformed"} {
+		if result, ok := got[id]; ok {
+			t.Errorf("message for another user got result %+v", result)
+		}
+	}
+}
+
+func TestDispatchRateLimitsUnknown(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	startClient(t, srv, Config{RateLimit: RateLimitConfig{
+		Limits: map[string]RateLimit{unknownClass: {PerSecond: 0.01, Burst: 2}},
+	}})
+
+	// Made-up types share one bucket.
+	for i, msgType := range []string{"a", "b", "c", "d"} {
+		srv.Send(map[string]any{"type": msgType, "id": string(rune('1' + i)), "userId": "user"})
+	}
+
+	got := results(t, srv, "ok")
+	for id, status := range map[string]string{
+		"1": ResultUnsupported, "2": ResultUnsupported,
+		"3": ResultRateLimited, "4": ResultRateLimited,
+	} {
+		if got[id].Status != status {
+			t.Errorf("result for %s = %q, want %q", id, got[id].Status, status)
+		}
+	}
+}
+
+func TestDispatchMetricsChecked(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+	c := startClient(t, srv, Config{})
+	c.SetDevice("this desktop", "Desktop")
+
+	srv.Send(map[string]any{"type": "getMetrics", "id": "foreign", "userId": "someone else"})
+	srv.Send(map[string]any{"type": "getMetrics", "id": "other device", "userId": "user", "deviceId": "other desktop"})
+	srv.Send(map[string]any{"type": "getMetrics", "id": "mine", "userId": "user", "deviceId": "this desktop"})
+	results(t, srv, "ok")
+
+	frame, err := srv.WaitFrame("metrics", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics MetricsMessage
+	frame.Decode(&metrics)
+	if metrics.ID != "mine" {
+		t.Errorf("metrics answered %q, want only mine", metrics.ID)
+	}
+	if frame, err := srv.WaitFrame("metrics", 100*time.Millisecond); err == nil {
+		t.Errorf("unexpected metrics frame %s", frame.Data)
+	}
+}
+
+func TestDispatchLongCommand(t *testing.T) {
+	srv := wstest.NewServer("token")
+	defer srv.Close()
+
+	// The command runs for several liveness timeouts; the read pump keeps
+	// reading pongs meanwhile, so the connection stays up.
+	started := make(chan struct{})
+	c := NewClient(srv.URL, "token", "user")
+	c.SetConfig(Config{Heartbeat: HeartbeatConfig{PingIntervalMs: 20, LivenessTimeoutMs: 50}})
+	c.SetKeyPressHandler(func(msg KeyCodeMessage) error {
+		if msg.ID == "long" {
+			close(started)
+			time.Sleep(300 * time.Millisecond)
+		}
+		return nil
+	})
+	go c.Run(t.Context())
+	if _, err := srv.WaitFrame("hello", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.SendKeyCode("user", "long", "media.next")
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("long command did not start")
+	}
+	got := results(t, srv, "ok")
+	if got["long"].Status != ResultOK {
+		t.Errorf("result for the long command = %+v, want ok", got["long"])
+	}
+	if n := srv.Accepted(); n != 1 {
+		t.Errorf("%d connections accepted, want the first one kept", n)
+	}
+}
@@ -0,0 +1,381 @@
+// Package wstest provides an in-process server that speaks the web app's
+// /_ws/ protocol, for exercising websocket.Client without the real web app.
+package wstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Frame is a message captured from a client.
+type Frame struct {
+	Type string
+	Data []byte
+}
+
+// Decode unmarshals the frame into v.
+func (f Frame) Decode(v any) error {
+	return json.Unmarshal(f.Data, v)
+}
+
+// Server accepts websocket connections on /_ws/ from clients presenting the
+// expected bearer token. It answers hello messages with a welcome, records
+// every frame clients send, and lets the caller push frames and drop
+// connections.
+type Server struct {
+	// URL is the base URL to pass to websocket.NewClient as the web app URL.
+	URL string
+
+	srv      *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu           sync.Mutex
+	changed      *sync.Cond
+	token        string
+	conns        []*serverConn
+	accepted     int
+	authFailures int
+	headers      []http.Header
+	frames       []Frame
+	taken        []bool
+	rejectHello  *helloRejection
+	refreshable  map[string]bool
+	refreshes    int
+	clockSkew    time.Duration
+	ignorePings  bool
+}
+
+type serverConn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+type helloRejection struct {
+	Type               string `json:"type"`
+	Reason             string `json:"reason"`
+	MinProtocolVersion int    `json:"minProtocolVersion,omitempty"`
+}
+
+// NewServer starts a server that accepts token. It also serves the web app's
+// /api/refreshtoken endpoint for tokens allowed with AllowRefresh. Call Close
+// when done.
+func NewServer(token string) *Server {
+	s := &Server{token: token}
+	s.changed = sync.NewCond(&s.mu)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ws/", s.handleWebSocket)
+	mux.HandleFunc("/api/refreshtoken", s.handleRefresh)
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close drops every connection and shuts the server down.
+func (s *Server) Close() {
+	s.Drop()
+	s.srv.Close()
+}
+
+// SetToken changes the accepted token, e.g. to make a client's token expire.
+// Existing connections are kept.
+func (s *Server) SetToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.token = token
+}
+
+// SetClockSkew sets the server's clock ahead of the local one by skew, or
+// behind it if skew is negative, as seen in welcomes and SendKeyCode.
+func (s *Server) SetClockSkew(skew time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clockSkew = skew
+}
+
+// Now returns the server's time.
+func (s *Server) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Now().Add(s.clockSkew)
+}
+
+// IgnorePings makes the server stop answering pings, or answer them again,
+// as a half-open connection would look to the client.
+func (s *Server) IgnorePings(ignore bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ignorePings = ignore
+}
+
+// RejectHello makes the server answer the next hellos with a rejection, as a
+// server that no longer supports the client's protocol version would.
+func (s *Server) RejectHello(reason string, minProtocolVersion int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rejectHello = &helloRejection{Type: "rejected", Reason: reason, MinProtocolVersion: minProtocolVersion}
+}
+
+// AllowRefresh lets a client holding the expired token get the currently
+// accepted one from /api/refreshtoken, once.
+func (s *Server) AllowRefresh(expired string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.refreshable == nil {
+		s.refreshable = make(map[string]bool)
+	}
+	s.refreshable[expired] = true
+}
+
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	bearer, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	s.mu.Lock()
+	ok := r.Method == http.MethodPost && s.refreshable[bearer]
+	delete(s.refreshable, bearer)
+	token := s.token
+	if ok {
+		s.refreshes++
+	}
+	s.changed.Broadcast()
+	s.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "session expired", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"sessionToken": token})
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	token := s.token
+	s.mu.Unlock()
+
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || bearer != token {
+		s.mu.Lock()
+		s.authFailures++
+		s.changed.Broadcast()
+		s.mu.Unlock()
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	sc := &serverConn{conn: conn}
+	conn.SetPingHandler(func(data string) error {
+		s.mu.Lock()
+		ignore := s.ignorePings
+		s.mu.Unlock()
+		if ignore {
+			return nil
+		}
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	s.mu.Lock()
+	s.conns = append(s.conns, sc)
+	s.accepted++
+	s.headers = append(s.headers, r.Header.Clone())
+	s.changed.Broadcast()
+	s.mu.Unlock()
+
+	defer s.remove(sc)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var envelope struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal(data, &envelope)
+
+		s.mu.Lock()
+		s.frames = append(s.frames, Frame{Type: envelope.Type, Data: data})
+		s.taken = append(s.taken, false)
+		rejection := s.rejectHello
+		s.changed.Broadcast()
+		s.mu.Unlock()
+
+		if envelope.Type == "hello" {
+			if rejection != nil {
+				sc.writeJSON(rejection)
+			} else {
+				sc.writeJSON(map[string]any{"type": "welcome", "protocolVersion": 1, "ts": s.Now().UnixMilli()})
+			}
+		}
+	}
+}
+
+func (s *Server) remove(sc *serverConn) {
+	sc.conn.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, c := range s.conns {
+		if c == sc {
+			s.conns = append(s.conns[:i], s.conns[i+1:]...)
+			break
+		}
+	}
+	s.changed.Broadcast()
+}
+
+func (sc *serverConn) writeJSON(v any) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	return sc.conn.WriteJSON(v)
+}
+
+// Send pushes v as a JSON frame to every connected client.
+func (s *Server) Send(v any) error {
+	s.mu.Lock()
+	conns := append([]*serverConn(nil), s.conns...)
+	s.mu.Unlock()
+
+	if len(conns) == 0 {
+		return fmt.Errorf("no client connected")
+	}
+	for _, sc := range conns {
+		if err := sc.writeJSON(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendKeyCode pushes a keyCode command for userID, e.g. "media.play_pause".
+func (s *Server) SendKeyCode(userID, id, key string) error {
+	return s.Send(map[string]any{
+		"type":    "keyCode",
+		"id":      id,
+		"ts":      s.Now().UnixMilli(),
+		"keyCode": key,
+		"userId":  userID,
+	})
+}
+
+// Drop closes every connection without a close handshake, as a network
+// failure would.
+func (s *Server) Drop() {
+	s.mu.Lock()
+	conns := append([]*serverConn(nil), s.conns...)
+	s.mu.Unlock()
+
+	for _, sc := range conns {
+		sc.conn.NetConn().Close()
+	}
+}
+
+// Accepted returns how many connections the server has accepted so far.
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.accepted
+}
+
+// AuthFailures returns how many connection attempts had a wrong token.
+func (s *Server) AuthFailures() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.authFailures
+}
+
+// Refreshes returns how many tokens /api/refreshtoken has handed out.
+func (s *Server) Refreshes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.refreshes
+}
+
+// Headers returns the request headers of every accepted connection, in order.
+func (s *Server) Headers() []http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]http.Header(nil), s.headers...)
+}
+
+// Frames returns every frame captured so far.
+func (s *Server) Frames() []Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Frame(nil), s.frames...)
+}
+
+// WaitAccepted waits until the server has accepted n connections in total.
+func (s *Server) WaitAccepted(n int, timeout time.Duration) error {
+	return s.wait(timeout, func() bool { return s.accepted >= n },
+		func() error { return fmt.Errorf("%d of %d connections accepted", s.accepted, n) })
+}
+
+// WaitAuthFailures waits until n connection attempts have been refused.
+func (s *Server) WaitAuthFailures(n int, timeout time.Duration) error {
+	return s.wait(timeout, func() bool { return s.authFailures >= n },
+		func() error { return fmt.Errorf("%d of %d auth failures", s.authFailures, n) })
+}
+
+// WaitFrame returns the oldest captured frame of msgType that no earlier
+// WaitFrame call has returned, waiting for one to arrive if needed.
+func (s *Server) WaitFrame(msgType string, timeout time.Duration) (Frame, error) {
+	var frame Frame
+	err := s.wait(timeout, func() bool {
+		for i, f := range s.frames {
+			if !s.taken[i] && f.Type == msgType {
+				s.taken[i] = true
+				frame = f
+				return true
+			}
+		}
+		return false
+	}, func() error { return fmt.Errorf("no %s frame received", msgType) })
+	return frame, err
+}
+
+// wait blocks until done, called with s.mu held, returns true.
+func (s *Server) wait(timeout time.Duration, done func() bool, timedOut func() error) error {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		s.mu.Lock()
+		s.changed.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !done() {
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("timed out after %v: %v", timeout, timedOut())
+		}
+		s.changed.Wait()
+	}
+	return nil
+}
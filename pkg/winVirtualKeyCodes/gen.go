@@ -0,0 +1,153 @@
+//go:build ignore
+
+// gen.go builds codes.go from keys.txt. It refuses to write anything if the
+// list has duplicate names, two canonical names for one code, codes outside
+// 0x01-0xFE or aliases of unknown keys, so a broken list never reaches the
+// generated table.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type key struct {
+	name string
+	code uint16
+}
+
+func main() {
+	keys, aliases, err := parse("keys.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := generate(keys, aliases)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile("codes.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parse(path string) ([]key, map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var keys []key
+	aliases := make(map[string]string)
+	names := make(map[string]bool)
+	codes := make(map[uint16]string)
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		switch {
+		case len(fields) == 3 && fields[1] == "=":
+			if names[fields[0]] {
+				return nil, nil, fmt.Errorf("%s:%d: duplicate name %s", path, line, fields[0])
+			}
+			names[fields[0]] = true
+			aliases[fields[0]] = fields[2]
+		case len(fields) == 2:
+			code, err := strconv.ParseUint(fields[1], 0, 16)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s:%d: bad code %s: %v", path, line, fields[1], err)
+			}
+			if code < 0x01 || code > 0xFE {
+				return nil, nil, fmt.Errorf("%s:%d: code %s out of range", path, line, fields[1])
+			}
+			if names[fields[0]] {
+				return nil, nil, fmt.Errorf("%s:%d: duplicate name %s", path, line, fields[0])
+			}
+			if other, ok := codes[uint16(code)]; ok {
+				return nil, nil, fmt.Errorf("%s:%d: %s has the same code as %s, declare it as an alias", path, line, fields[0], other)
+			}
+			names[fields[0]] = true
+			codes[uint16(code)] = fields[0]
+			keys = append(keys, key{name: fields[0], code: uint16(code)})
+		default:
+			return nil, nil, fmt.Errorf("%s:%d: expected \"NAME CODE\" or \"ALIAS = NAME\"", path, line)
+		}
+
+		if !strings.HasPrefix(fields[0], "VK_") {
+			return nil, nil, fmt.Errorf("%s:%d: %s does not start with VK_", path, line, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for alias, target := range aliases {
+		if _, ok := aliases[target]; ok {
+			return nil, nil, fmt.Errorf("alias %s points at alias %s", alias, target)
+		}
+		if !names[target] {
+			return nil, nil, fmt.Errorf("alias %s points at unknown key %s", alias, target)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].code < keys[j].code })
+	return keys, aliases, nil
+}
+
+func generate(keys []key, aliases map[string]string) ([]byte, error) {
+	codes := make(map[string]uint16, len(keys))
+	for _, k := range keys {
+		codes[k.name] = k.code
+	}
+
+	aliasNames := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		aliasNames = append(aliasNames, alias)
+	}
+	sort.Strings(aliasNames)
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// Code generated by gen.go from keys.txt; DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package winVirtualKeyCodes")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// VirtualKeyCodes maps string key names, including aliases, to their Windows Virtual Key Code values")
+	fmt.Fprintln(&buf, "var VirtualKeyCodes = map[string]uint16{")
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "\t%q: 0x%02X,\n", k.name, k.code)
+	}
+	for _, alias := range aliasNames {
+		fmt.Fprintf(&buf, "\t%q: 0x%02X,\n", alias, codes[aliases[alias]])
+	}
+	fmt.Fprintln(&buf, "}")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// KeyNames maps each Virtual Key Code back to its canonical name")
+	fmt.Fprintln(&buf, "var KeyNames = map[uint16]string{")
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "\t0x%02X: %q,\n", k.code, k.name)
+	}
+	fmt.Fprintln(&buf, "}")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// Aliases maps alternative key names to the canonical name for the same code")
+	fmt.Fprintln(&buf, "var Aliases = map[string]string{")
+	for _, alias := range aliasNames {
+		fmt.Fprintf(&buf, "\t%q: %q,\n", alias, aliases[alias])
+	}
+	fmt.Fprintln(&buf, "}")
+
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,92 @@
+package winVirtualKeyCodes
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestGenerated checks that codes.go is what gen.go makes of keys.txt, so an
+// edit to either without regenerating is caught.
+func TestGenerated(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the generator")
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"gen.go", "keys.txt"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "run", "gen.go")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go run gen.go: %v\n%s", err, out)
+	}
+
+	want, err := os.ReadFile(filepath.Join(dir, "codes.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("codes.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("codes.go is out of date, run go generate")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for code, name := range KeyNames {
+		got, ok := Lookup(name)
+		if !ok || got != code {
+			t.Errorf("Lookup(%s) = 0x%02X, %v; want 0x%02X", name, got, ok, code)
+		}
+	}
+
+	for name, code := range VirtualKeyCodes {
+		canonical, ok := Name(code)
+		if !ok {
+			t.Errorf("%s (0x%02X) has no canonical name", name, code)
+			continue
+		}
+		if _, alias := Aliases[name]; !alias && canonical != name {
+			t.Errorf("Name(0x%02X) = %s, want %s", code, canonical, name)
+		}
+		if Canonical(name) != canonical {
+			t.Errorf("Canonical(%s) = %s, want %s", name, Canonical(name), canonical)
+		}
+	}
+}
+
+func TestAlias(t *testing.T) {
+	kana, ok := Lookup("VK_KANA")
+	if !ok {
+		t.Fatal("VK_KANA not found")
+	}
+	if code, ok := Lookup("VK_HANGUL"); !ok || code != kana {
+		t.Errorf("Lookup(VK_HANGUL) = 0x%02X, %v; want 0x%02X", code, ok, kana)
+	}
+	if got := Canonical("VK_HANGUL"); got != "VK_KANA" {
+		t.Errorf("Canonical(VK_HANGUL) = %s, want VK_KANA", got)
+	}
+	if name, _ := Name(kana); name != "VK_KANA" {
+		t.Errorf("Name(0x%02X) = %s, want VK_KANA", kana, name)
+	}
+	if err := Validate("VK_HANGUL"); err != nil {
+		t.Errorf("Validate(VK_HANGUL): %v", err)
+	}
+	if err := Validate("VK_NOPE"); err == nil {
+		t.Error("Validate(VK_NOPE): want error")
+	}
+}